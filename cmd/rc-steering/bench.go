@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/stats"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Bench stages that aren't reported by steering.Model
+const (
+	stageJpeg        = "jpeg"
+	stageCopyInputs  = "copy_inputs"
+	stageInvoke      = "invoke"
+	stageCopyOutputs = "copy_outputs"
+	stageTotal       = "total"
+)
+
+// benchStages is the display order of stages
+var benchStages = []string{
+	stageJpeg,
+	steering.StageResize,
+	steering.StageCrop,
+	steering.StagePack,
+	steering.StageRun,
+	stageCopyInputs,
+	stageInvoke,
+	stageCopyOutputs,
+	steering.StageDecode,
+	stageTotal,
+}
+
+// runBench measure time spent in each stage of frame processing
+func runBench(args []string) int {
+	var mf modelFlags
+	var format, output, threadsList string
+	var iterations, warmup, frameWidth, frameHeight int
+	logLevel := zapcore.InfoLevel
+
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s bench [flags] [jpeg file or directory]...\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Synthetic frames are used when no file is given.")
+		flags.PrintDefaults()
+	}
+	mf.register(flags)
+	flags.StringVar(&threadsList, "threads-list", "", "comma separated thread counts to bench, use -threads if not set")
+	flags.IntVar(&iterations, "iterations", 200, "number of frames measured for each thread count")
+	flags.IntVar(&warmup, "warmup", 10, "number of frames processed before measures")
+	flags.IntVar(&frameWidth, "frame-width", 160, "synthetic frame width")
+	flags.IntVar(&frameHeight, "frame-height", 120, "synthetic frame height")
+	flags.StringVar(&format, "format", "text", "report format: 'text' or 'json'")
+	flags.StringVar(&output, "output", "", "file where to write report, stdout if not set")
+	flags.Var(&logLevel, "log", "log level")
+	_ = flags.Parse(args)
+
+	if format != "text" && format != "json" {
+		fmt.Fprintf(flags.Output(), "unknown format '%v'\n", format)
+		flags.Usage()
+		return 1
+	}
+	if iterations <= 0 {
+		fmt.Fprintln(flags.Output(), "iterations must be positive")
+		flags.Usage()
+		return 1
+	}
+	threads, err := parseThreads(threadsList, mf.threads)
+	if err != nil {
+		fmt.Fprintf(flags.Output(), "invalid threads-list: %v\n", err)
+		flags.Usage()
+		return 1
+	}
+	defer initLogger(logLevel)()
+
+	var frames [][]byte
+	if flags.NArg() > 0 {
+		frames, err = readFrames(flags.Args())
+	} else {
+		frames, err = syntheticFrames(frameWidth, frameHeight, 10)
+	}
+	if err != nil {
+		zap.L().Error("unable to prepare frames", zap.Error(err))
+		return 1
+	}
+	if len(frames) == 0 {
+		zap.L().Error("no frame to bench")
+		return 1
+	}
+
+	var results []benchResult
+	for _, n := range threads {
+		mf := mf
+		mf.threads = n
+		result, err := bench(&mf, frames, warmup, iterations)
+		if err != nil {
+			zap.L().Error("bench failed", zap.Int("threads", n), zap.Error(err))
+			return 1
+		}
+		results = append(results, result)
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			zap.L().Error("unable to create output file", zap.String("file", output), zap.Error(err))
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "json" {
+		err = json.NewEncoder(out).Encode(results)
+	} else {
+		err = writeBenchText(out, results)
+	}
+	if err != nil {
+		zap.L().Error("unable to write report", zap.Error(err))
+		return 1
+	}
+	return 0
+}
+
+type benchResult struct {
+	Threads int `json:"threads"`
+	Frames  int `json:"frames"`
+	// Throughput is the number of frames processed by second, sequentially
+	Throughput float64                  `json:"throughput_fps"`
+	Stages     map[string]stats.Summary `json:"stages"`
+}
+
+func bench(mf *modelFlags, frames [][]byte, warmup, iterations int) (benchResult, error) {
+	model, eng, err := mf.newModel(context.Background())
+	if err != nil {
+		return benchResult{}, fmt.Errorf("unable to init model: %w", err)
+	}
+	defer model.Close()
+	if err := model.Load(); err != nil {
+		return benchResult{}, fmt.Errorf("unable to load model: %w", err)
+	}
+
+	profiler, _ := eng.(engine.Profiler)
+	durations := make(map[string]*stats.Durations)
+	add := func(stage string, d time.Duration) {
+		if _, ok := durations[stage]; !ok {
+			durations[stage] = &stats.Durations{}
+		}
+		durations[stage].Add(d)
+	}
+
+	// Synthetic timestamps feed models that expect many frames as a 20 fps stream
+	const frameInterval = 50 * time.Millisecond
+	history := model.NewFrameHistory(0)
+	t0 := time.Now()
+
+	var elapsed time.Duration
+	for i := 0; i < warmup+iterations; i++ {
+		measured := i >= warmup
+		start := time.Now()
+		img, err := jpeg.Decode(bytes.NewReader(frames[i%len(frames)]))
+		if err != nil {
+			return benchResult{}, fmt.Errorf("unable to decode frame: %w", err)
+		}
+		jpegDuration := time.Since(start)
+
+		record := func(string, time.Duration) {}
+		if measured {
+			record = add
+		}
+		if _, err := model.PredictSeqTimed(img, t0.Add(time.Duration(i)*frameInterval), history, record); err != nil {
+			return benchResult{}, fmt.Errorf("unable to run model: %w", err)
+		}
+		total := time.Since(start)
+		if !measured {
+			continue
+		}
+
+		elapsed += total
+		add(stageJpeg, jpegDuration)
+		add(stageTotal, total)
+		if profiler != nil {
+			s := profiler.LastRunStats()
+			add(stageCopyInputs, s.CopyInputs)
+			add(stageInvoke, s.Invoke)
+			add(stageCopyOutputs, s.CopyOutputs)
+		}
+	}
+
+	result := benchResult{
+		Threads:    mf.threads,
+		Frames:     iterations,
+		Throughput: float64(iterations) / elapsed.Seconds(),
+		Stages:     make(map[string]stats.Summary, len(durations)),
+	}
+	for stage, d := range durations {
+		result.Stages[stage] = d.Summary()
+	}
+	return result, nil
+}
+
+func writeBenchText(out io.Writer, results []benchResult) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	for _, r := range results {
+		fmt.Fprintf(w, "threads: %d, frames: %d, throughput: %.1f fps\n", r.Threads, r.Frames, r.Throughput)
+		fmt.Fprintln(w, "stage\tmean (ms)\tp50 (ms)\tp90 (ms)\tp99 (ms)\tmax (ms)\t")
+		for _, stage := range benchStages {
+			s, ok := r.Stages[stage]
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%.3f\t%.3f\t%.3f\t%.3f\t%.3f\t\n", stage, s.Mean, s.P50, s.P90, s.P99, s.Max)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+// parseThreads parse comma separated thread counts, return defaultThreads if list is empty
+func parseThreads(list string, defaultThreads int) ([]int, error) {
+	if strings.TrimSpace(list) == "" {
+		return []int{defaultThreads}, nil
+	}
+	var threads []int
+	for _, v := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("bad thread count '%v': %w", v, err)
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("thread count must be positive, got %d", n)
+		}
+		threads = append(threads, n)
+	}
+	return threads, nil
+}
+
+func readFrames(paths []string) ([][]byte, error) {
+	files, err := listImages(paths)
+	if err != nil {
+		return nil, err
+	}
+	frames := make([][]byte, 0, len(files))
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read frame: %w", err)
+		}
+		frames = append(frames, b)
+	}
+	return frames, nil
+}
+
+// syntheticFrames generate n jpeg frames of random noise
+func syntheticFrames(width, height, n int) ([][]byte, error) {
+	rnd := rand.New(rand.NewSource(1))
+	frames := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				img.Set(x, y, color.RGBA{R: uint8(rnd.Intn(256)), G: uint8(rnd.Intn(256)), B: uint8(rnd.Intn(256)), A: 255})
+			}
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, nil); err != nil {
+			return nil, fmt.Errorf("unable to encode synthetic frame: %w", err)
+		}
+		frames = append(frames, buf.Bytes())
+	}
+	return frames, nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_parseThreads(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		want    []int
+		wantErr bool
+	}{
+		{name: "default", list: "", want: []int{4}},
+		{name: "list", list: "1, 2,4", want: []int{1, 2, 4}},
+		{name: "not a number", list: "1,a", wantErr: true},
+		{name: "zero", list: "0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseThreads(tt.list, 4)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThreads() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseThreads() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"strconv"
+	"strings"
+)
+
+// ensembleFlags configure models combined into a single steering decision
+type ensembleFlags struct {
+	members     []memberSpec
+	combination string
+}
+
+// memberSpec describe an ensemble member, from a 'model=<path>', 'oci=<registry>/<repository>:<tag>' or
+// 'oci=<registry>/<repository>@<digest>' flag value with optional 'weight' and 'topic' fields, comma separated
+type memberSpec struct {
+	path                               string
+	ociRegistry, ociRepository, ociTag string
+	weight                             float64
+	topic                              string
+}
+
+func (f *ensembleFlags) register(fs *flag.FlagSet) {
+	fs.Func("ensemble-member", "model of ensemble, repeat flag for each member: 'model=<path>' or 'oci=<registry>/<repository>:<tag>' or 'oci=<registry>/<repository>@<digest>', optionally followed by ',weight=<weight>' and ',topic=<mqtt topic>' to publish member steering for debug. Exclusive with model and oci-model-* flags", func(v string) error {
+		m, err := parseMemberSpec(v)
+		if err != nil {
+			return err
+		}
+		f.members = append(f.members, m)
+		return nil
+	})
+	fs.StringVar(&f.combination, "ensemble-combination", "average", "combination of ensemble predictions: 'average' weighted by members weights, 'confidence-max' or 'median'")
+}
+
+func (f *ensembleFlags) enabled() bool {
+	return len(f.members) > 0
+}
+
+func parseMemberSpec(v string) (memberSpec, error) {
+	m := memberSpec{weight: 1.}
+	for _, field := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return m, fmt.Errorf("bad ensemble member field '%v', expected 'key=value'", field)
+		}
+		switch strings.TrimSpace(key) {
+		case "model":
+			m.path = value
+		case "oci":
+			registry, ref, ok := strings.Cut(value, "/")
+			repo, tag := ref, ""
+			if strings.Contains(ref, "@") {
+				// Digest is kept in repository, as '<repository>@<digest>'
+				ok = ok && !strings.HasPrefix(ref, "@") && !strings.HasSuffix(ref, "@")
+			} else if i := strings.LastIndex(ref, ":"); i > 0 && i < len(ref)-1 {
+				repo, tag = ref[:i], ref[i+1:]
+			} else {
+				ok = false
+			}
+			if !ok {
+				return m, fmt.Errorf("bad oci reference '%v', expected '<registry>/<repository>:<tag>' or '<registry>/<repository>@<digest>'", value)
+			}
+			m.ociRegistry, m.ociRepository, m.ociTag = registry, repo, tag
+		case "weight":
+			w, err := strconv.ParseFloat(value, 32)
+			if err != nil || w <= 0 {
+				return m, fmt.Errorf("bad weight '%v', expected a positive number", value)
+			}
+			m.weight = w
+		case "topic":
+			m.topic = value
+		default:
+			return m, fmt.Errorf("unknown ensemble member field '%v'", key)
+		}
+	}
+	if (m.path == "") == (m.ociRepository == "") {
+		return m, fmt.Errorf("ensemble member '%v' need either a model path or an oci reference", v)
+	}
+	return m, nil
+}
+
+// newEnsemble instantiate members models with engine settings of base flags. Members configuration comes from model
+// names or oci annotations
+func (f *ensembleFlags) newEnsemble(ctx context.Context, base modelFlags) (*steering.Ensemble, error) {
+	combination, err := steering.ParseCombination(f.combination)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]steering.Member, 0, len(f.members))
+	for _, spec := range f.members {
+		model, err := spec.newModel(ctx, base)
+		if err != nil {
+			return nil, fmt.Errorf("unable to init ensemble member: %w", err)
+		}
+		members = append(members, steering.Member{Model: model, Weight: float32(spec.weight), Topic: spec.topic})
+	}
+	return steering.NewEnsemble(combination, members...), nil
+}
+
+// newModel instantiate model of spec with engine settings of base flags. Model configuration comes from model name
+// or oci annotations
+func (m memberSpec) newModel(ctx context.Context, base modelFlags) (*steering.Model, error) {
+	mf := modelFlags{
+		path:          m.path,
+		dir:           base.dir,
+		ociRegistry:   m.ociRegistry,
+		ociRepository: m.ociRepository,
+		ociTag:        m.ociTag,
+		// Members are usually pulled from the same registry
+		registryOptions: base.registryOptions,
+		backend:         base.backend,
+		edgeDevice:      base.edgeDevice,
+		edgePool:        base.edgePool,
+		edgeVerbosity:   base.edgeVerbosity,
+		threads:         base.threads,
+	}
+	model, _, err := mf.newModel(ctx)
+	return model, err
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func Test_parseMemberSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    memberSpec
+		wantErr bool
+	}{
+		{name: "path", value: "model=/models/model_linear_160x120h20_edgetpu.tflite", want: memberSpec{path: "/models/model_linear_160x120h20_edgetpu.tflite", weight: 1.}},
+		{
+			name:  "oci with weight and topic",
+			value: "oci=registry:5000/robocar/model:v2,weight=2,topic=debug/steering",
+			want:  memberSpec{ociRegistry: "registry:5000", ociRepository: "robocar/model", ociTag: "v2", weight: 2., topic: "debug/steering"},
+		},
+		{
+			name:  "oci pinned by digest",
+			value: "oci=registry/robocar/model@sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a",
+			want:  memberSpec{ociRegistry: "registry", ociRepository: "robocar/model@sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", weight: 1.},
+		},
+		{name: "oci without tag", value: "oci=registry/model", wantErr: true},
+		{name: "oci without digest", value: "oci=registry/model@", wantErr: true},
+		{name: "missing model", value: "weight=2", wantErr: true},
+		{name: "path and oci", value: "model=a.tflite,oci=registry/model:v1", wantErr: true},
+		{name: "bad weight", value: "model=a.tflite,weight=-1", wantErr: true},
+		{name: "unknown field", value: "model=a.tflite,color=red", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMemberSpec(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMemberSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseMemberSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
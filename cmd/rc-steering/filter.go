@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/filter"
+)
+
+// filterFlags configure filters applied on steering predictions before publishing
+type filterFlags struct {
+	median   int
+	emaAlpha float64
+	maxRate  float64
+	deadband float64
+}
+
+func (f *filterFlags) register(fs *flag.FlagSet) {
+	fs.IntVar(&f.median, "filter-median", 0, "smooth steering with median of this number of last predictions, 0 to disable")
+	fs.Float64Var(&f.emaAlpha, "filter-ema-alpha", 0., "smooth steering with an exponential moving average of this weight in ]0, 1], 0 to disable")
+	fs.Float64Var(&f.deadband, "filter-deadband", 0., "ignore steering changes smaller than this value, 0 to disable")
+	fs.Float64Var(&f.maxRate, "filter-max-rate", 0., "limit steering changes to this value per second, 0 to disable")
+}
+
+// newFilter return filters chained in order median, ema, deadband and rate limit, or nil if none is enabled
+func (f *filterFlags) newFilter() (filter.Filter, error) {
+	var filters []filter.Filter
+	if f.median < 0 {
+		return nil, fmt.Errorf("invalid median size %d", f.median)
+	}
+	if f.median > 1 {
+		filters = append(filters, filter.NewMedian(f.median))
+	}
+	if f.emaAlpha < 0 || f.emaAlpha > 1 {
+		return nil, fmt.Errorf("invalid ema alpha %v, must be in ]0, 1]", f.emaAlpha)
+	}
+	if f.emaAlpha > 0 && f.emaAlpha < 1 {
+		filters = append(filters, filter.NewEMA(float32(f.emaAlpha)))
+	}
+	if f.deadband < 0 {
+		return nil, fmt.Errorf("invalid deadband %v", f.deadband)
+	}
+	if f.deadband > 0 {
+		filters = append(filters, filter.NewDeadband(float32(f.deadband)))
+	}
+	if f.maxRate < 0 {
+		return nil, fmt.Errorf("invalid max rate %v", f.maxRate)
+	}
+	if f.maxRate > 0 {
+		filters = append(filters, filter.NewRateLimit(float32(f.maxRate)))
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	return filter.Chain(filters...), nil
+}
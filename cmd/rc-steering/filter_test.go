@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func Test_filterFlags_newFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		flags      filterFlags
+		wantFilter bool
+		wantErr    bool
+	}{
+		{name: "disabled", flags: filterFlags{}},
+		{name: "median of 1 is disabled", flags: filterFlags{median: 1}},
+		{name: "ema", flags: filterFlags{emaAlpha: 0.5}, wantFilter: true},
+		{name: "all", flags: filterFlags{median: 3, emaAlpha: 0.5, maxRate: 2., deadband: 0.05}, wantFilter: true},
+		{name: "bad ema", flags: filterFlags{emaAlpha: 1.5}, wantErr: true},
+		{name: "bad median", flags: filterFlags{median: -1}, wantErr: true},
+		{name: "bad rate", flags: filterFlags{maxRate: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.flags.newFilter()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got != nil) != tt.wantFilter {
+				t.Errorf("newFilter() = %v, want filter %v", got, tt.wantFilter)
+			}
+		})
+	}
+}
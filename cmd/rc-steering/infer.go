@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"image"
+	_ "image/jpeg"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runInfer run model on jpeg files, without mqtt broker
+func runInfer(args []string) int {
+	var mf modelFlags
+	var format, output string
+	logLevel := zapcore.InfoLevel
+
+	flags := flag.NewFlagSet("infer", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s infer [flags] <file or directory>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	mf.register(flags)
+	flags.StringVar(&format, "format", "csv", "output format: 'csv' or 'json' (one object per line)")
+	flags.StringVar(&output, "output", "", "file where to write results, stdout if not set")
+	flags.Var(&logLevel, "log", "log level")
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+	defer initLogger(logLevel)()
+
+	files, err := listImages(flags.Args())
+	if err != nil {
+		zap.L().Error("unable to list images", zap.Error(err))
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			zap.L().Error("unable to create output file", zap.String("file", output), zap.Error(err))
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	w, err := newResultWriter(format, out)
+	if err != nil {
+		zap.L().Error("invalid output format", zap.Error(err))
+		return 1
+	}
+
+	model, _, err := mf.newModel(context.Background())
+	if err != nil {
+		zap.L().Error("unable to init model", zap.Error(err))
+		return 1
+	}
+	defer model.Close()
+	if err := model.Load(); err != nil {
+		zap.L().Error("unable to load model", zap.Error(err))
+		return 1
+	}
+
+	status := 0
+	for _, file := range files {
+		prediction, err := predictFile(model, file)
+		if err != nil {
+			zap.L().Error("unable to infer image", zap.String("file", file), zap.Error(err))
+			status = 1
+			continue
+		}
+		if err := w.write(file, prediction); err != nil {
+			zap.L().Error("unable to write result", zap.Error(err))
+			return 1
+		}
+	}
+	if err := w.flush(); err != nil {
+		zap.L().Error("unable to write result", zap.Error(err))
+		return 1
+	}
+	return status
+}
+
+func predictFile(model *steering.Model, file string) (steering.Prediction, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return steering.Prediction{}, fmt.Errorf("unable to open image: %w", err)
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return steering.Prediction{}, fmt.Errorf("unable to decode image: %w", err)
+	}
+	return model.Predict(img)
+}
+
+// listImages return jpeg files from paths. Directories are walked recursively, files are sorted by name
+func listImages(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		var dirFiles []string
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(p)) {
+			case ".jpg", ".jpeg":
+				dirFiles = append(dirFiles, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to walk directory '%v': %w", path, err)
+		}
+		sort.Strings(dirFiles)
+		files = append(files, dirFiles...)
+	}
+	return files, nil
+}
+
+type inferResult struct {
+	File               string   `json:"file"`
+	Steering           float32  `json:"steering"`
+	SteeringConfidence float32  `json:"steering_confidence"`
+	Throttle           *float32 `json:"throttle,omitempty"`
+	ThrottleConfidence *float32 `json:"throttle_confidence,omitempty"`
+}
+
+func newInferResult(file string, prediction steering.Prediction) inferResult {
+	r := inferResult{
+		File:               file,
+		Steering:           prediction.Steering,
+		SteeringConfidence: prediction.SteeringConfidence,
+	}
+	if prediction.HasThrottle {
+		r.Throttle = &prediction.Throttle
+		r.ThrottleConfidence = &prediction.ThrottleConfidence
+	}
+	return r
+}
+
+type resultWriter interface {
+	write(file string, prediction steering.Prediction) error
+	flush() error
+}
+
+func newResultWriter(format string, w io.Writer) (resultWriter, error) {
+	switch format {
+	case "csv":
+		return &csvResultWriter{w: csv.NewWriter(w)}, nil
+	case "json":
+		return &jsonResultWriter{enc: json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format '%v'", format)
+	}
+}
+
+type csvResultWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (c *csvResultWriter) write(file string, prediction steering.Prediction) error {
+	if !c.headerWritten {
+		if err := c.w.Write([]string{"file", "steering", "steering_confidence", "throttle", "throttle_confidence"}); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	r := newInferResult(file, prediction)
+	return c.w.Write([]string{r.File, formatFloat(&r.Steering), formatFloat(&r.SteeringConfidence), formatFloat(r.Throttle), formatFloat(r.ThrottleConfidence)})
+}
+
+func (c *csvResultWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formatFloat return empty string for nil value
+func formatFloat(v *float32) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*v), 'f', -1, 32)
+}
+
+type jsonResultWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonResultWriter) write(file string, prediction steering.Prediction) error {
+	return j.enc.Encode(newInferResult(file, prediction))
+}
+
+func (j *jsonResultWriter) flush() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func Test_listImages(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"b.jpg", "a.JPEG", "notes.txt", "sub/c.jpg"} {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unable to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatalf("unable to create file: %v", err)
+		}
+	}
+
+	got, err := listImages([]string{filepath.Join(dir, "notes.txt"), dir})
+	if err != nil {
+		t.Fatalf("listImages() error = %v", err)
+	}
+	want := []string{
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(dir, "a.JPEG"),
+		filepath.Join(dir, "b.jpg"),
+		filepath.Join(dir, "sub/c.jpg"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("listImages() = %v, want %v", got, want)
+	}
+}
+
+func Test_resultWriter(t *testing.T) {
+	predictions := []steering.Prediction{
+		{Steering: -0.5, SteeringConfidence: 0.8},
+		{Steering: 0.25, SteeringConfidence: 0.6, HasThrottle: true, Throttle: 0.3, ThrottleConfidence: 0.9},
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "csv",
+			want: "file,steering,steering_confidence,throttle,throttle_confidence\n" +
+				"0.jpg,-0.5,0.8,,\n" +
+				"1.jpg,0.25,0.6,0.3,0.9\n",
+		},
+		{
+			format: "json",
+			want: `{"file":"0.jpg","steering":-0.5,"steering_confidence":0.8}` + "\n" +
+				`{"file":"1.jpg","steering":0.25,"steering_confidence":0.6,"throttle":0.3,"throttle_confidence":0.9}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newResultWriter(tt.format, &buf)
+			if err != nil {
+				t.Fatalf("newResultWriter() error = %v", err)
+			}
+			for i, p := range predictions {
+				if err := w.write(strconv.Itoa(i)+".jpg", p); err != nil {
+					t.Fatalf("write() error = %v", err)
+				}
+			}
+			if err := w.flush(); err != nil {
+				t.Fatalf("flush() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("output = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/tflite"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/oci"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"github.com/opencontainers/go-digest"
+	"go.uber.org/zap"
+	"os"
+	"strings"
+)
+
+// modelFlags configure model and inference engine, shared by all commands
+type modelFlags struct {
+	path, dir                          string
+	ociRegistry, ociRepository, ociTag string
+	registryOptions                    oci.RegistryOptions
+	backend, edgeDevice                string
+	edgePool                           bool
+	edgeVerbosity, threads             int
+	imgWidth, imgHeight, horizon       int
+	steeringOutput, throttleOutput     string
+	// preprocessing and decoding settings, by name
+	preprocessing map[string]string
+	decoding      map[string]string
+
+	// ociDigest is the digest of last model pulled by newModel
+	ociDigest digest.Digest
+}
+
+func (f *modelFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.path, "model", "", "path to model file")
+	fs.StringVar(&f.ociRegistry, "oci-model-registry", "", "oci registry where to fetch model")
+	fs.StringVar(&f.ociRepository, "oci-model-repository", "", "oci repository where to fetch model, may be pinned to a digest as '<repository>@sha256:<digest>'")
+	fs.StringVar(&f.ociTag, "oci-model-tag", "", "oci tag name, or digest 'sha256:<digest>', for model to pull")
+	registerRegistryFlags(fs, &f.registryOptions)
+	fs.BoolVar(&f.registryOptions.RequireOnline, "oci-require-online", false, "fail when oci registry is unreachable instead of starting with last model pulled")
+	fs.StringVar(&f.dir, "models-dir", "/tmp/robocar/models", "path where to store model file")
+	fs.StringVar(&f.backend, "engine", "auto", "inference engine to use: 'edgetpu', 'cpu' or 'auto' to fallback on cpu when no Edge TPU is found")
+	fs.IntVar(&f.threads, "threads", tflite.DefaultNumThreads, "number of threads used by cpu kernels")
+	fs.IntVar(&f.edgeVerbosity, "edge-verbosity", 0, "Edge TPU Verbosity")
+	fs.StringVar(&f.edgeDevice, "edge-device", "", "Edge TPU device to use: 'usb', 'pci', 'usb:<path>', 'pci:<path>' or device path, first device found if not set")
+	fs.BoolVar(&f.edgePool, "edge-pool", false, "spread frames over all Edge TPU devices matching edge-device")
+	fs.IntVar(&f.imgWidth, "img-width", 0, "image width expected by model")
+	fs.IntVar(&f.imgHeight, "img-height", 0, "image height expected by model")
+	fs.IntVar(&f.horizon, "horizon", 0, "upper zone to crop from image. Models expect size 'imgHeight - horizon'")
+	fs.StringVar(&f.steeringOutput, "steering-output", "", "index or name of model output to use for steering, search output named 'angle' or 'steering' if not set")
+	fs.StringVar(&f.throttleOutput, "throttle-output", "", "index or name of model output to use for throttle, search output named 'throttle' if not set")
+
+	f.preprocessing = make(map[string]string)
+	preprocessingUsages := map[string]string{
+		steering.PreprocessingResizeFilter: "resize filter: 'nearest', 'box', 'bilinear', 'bicubic' or 'lanczos', 'nearest' if not set",
+		steering.PreprocessingCrop:         "crop box 'x0,y0,x1,y1' of resized image sent to model, remove horizon rows at top if not set",
+		steering.PreprocessingColorOrder:   "color order expected by model: 'rgb', 'bgr' or 'gray', 'rgb' if not set",
+		steering.PreprocessingMean:         "mean to subtract from values in [0, 1], one value or one value by channel comma separated",
+		steering.PreprocessingStd:          "standard deviation to divide values by after mean subtraction, one value or one value by channel comma separated",
+	}
+	for _, setting := range steering.PreprocessingSettings {
+		setting := setting
+		fs.Func(strings.ReplaceAll(setting, "_", "-"), preprocessingUsages[setting]+", override oci annotation '"+setting+"'", func(v string) error {
+			f.preprocessing[setting] = v
+			return nil
+		})
+	}
+
+	f.decoding = make(map[string]string)
+	decodingUsages := map[string]string{
+		steering.DecodingDecoder:        "decoder of categorical outputs: 'argmax', 'expectation' for the probability-weighted mean of bins or 'topk' for the weighted mean of the top-k bins, 'argmax' if not set",
+		steering.DecodingTopK:           "number of bins used by 'topk' decoder, 3 if not set",
+		steering.DecodingConfidence:     "confidence of categorical outputs: 'score' of the decoded bins or 'entropy' of bins probabilities, 'score' if not set",
+		steering.DecodingSteeringOffset: "value of first steering bin, -1 if not set",
+		steering.DecodingSteeringRange:  "range covered by steering bins, as donkeycar linear_unbin, 2 if not set",
+		steering.DecodingThrottleOffset: "value of first throttle bin, 0 if not set",
+		steering.DecodingThrottleRange:  "range covered by throttle bins, as donkeycar linear_unbin, 0.5 if not set",
+	}
+	for _, setting := range steering.DecodingSettings {
+		setting := setting
+		fs.Func(strings.ReplaceAll(setting, "_", "-"), decodingUsages[setting]+", override oci annotation '"+setting+"'", func(v string) error {
+			f.decoding[setting] = v
+			return nil
+		})
+	}
+}
+
+// pinned return flags of model d, pulled from the same repository
+func (f modelFlags) pinned(d digest.Digest) modelFlags {
+	repo, _, _ := strings.Cut(f.ociRepository, "@")
+	f.ociRepository, f.ociTag = repo+"@"+d.String(), ""
+	return f
+}
+
+// registerRegistryFlags register flags of oci registry access and authentication
+func registerRegistryFlags(fs *flag.FlagSet, o *oci.RegistryOptions) {
+	fs.BoolVar(&o.PlainHTTP, "oci-plain-http", os.Getenv("OCI_PLAIN_HTTP") != "", "use plain http instead of https to access oci registry, true if OCI_PLAIN_HTTP env variable is set")
+	fs.StringVar(&o.Username, "oci-username", os.Getenv("OCI_USERNAME"), "username for oci registry basic authentication, use OCI_USERNAME env if args not set")
+	fs.StringVar(&o.Password, "oci-password", os.Getenv("OCI_PASSWORD"), "password for oci registry basic authentication, use OCI_PASSWORD env if args not set")
+	fs.StringVar(&o.Token, "oci-token", os.Getenv("OCI_TOKEN"), "bearer token for oci registry authentication, use OCI_TOKEN env if args not set")
+	fs.StringVar(&o.DockerConfig, "oci-docker-config", oci.DefaultDockerConfig(), "docker config.json where to find oci registry credentials when no username or token is set")
+	fs.StringVar(&o.CAFile, "oci-ca-file", os.Getenv("OCI_CA_FILE"), "PEM bundle of certificate authorities trusted to access oci registry, in addition to system ones, use OCI_CA_FILE env if args not set")
+	fs.BoolVar(&o.InsecureSkipVerify, "oci-insecure-skip-verify", false, "don't verify oci registry certificate")
+}
+
+// validate check flags consistency before any model is fetched
+func (f *modelFlags) validate() error {
+	if engine.ParseBackend(f.backend) == engine.BackendUnknown {
+		return fmt.Errorf("unknown engine '%v'", f.backend)
+	}
+	if f.path == "" && f.ociRepository == "" {
+		return fmt.Errorf("model path or oci image is mandatory")
+	}
+	if f.path != "" && f.ociRepository != "" {
+		return fmt.Errorf("model path and oci image are exclusives")
+	}
+	return nil
+}
+
+// newModel resolve model configuration, from its name or oci annotations, and init inference engine. Returned
+// model isn't loaded
+func (f *modelFlags) newModel(ctx context.Context) (*steering.Model, engine.Engine, error) {
+	if err := f.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	modelPath := f.path
+	steeringOutput, throttleOutput := f.steeringOutput, f.throttleOutput
+	var modelType tools.ModelType
+	var width, height, horizon int
+	var annotations map[string]string
+	var err error
+
+	if modelPath != "" {
+		modelType, width, height, horizon, err = parseModelName(modelPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad model name '%v', unable to detect configuration from name pattern: %w", modelPath, err)
+		}
+	} else {
+		model, err := oci.PullOciImage(ctx, f.ociRegistry, f.ociRepository, f.ociTag, f.dir, f.registryOptions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to pull oci image '%v/%v:%v': %w", f.ociRegistry, f.ociRepository, f.ociTag, err)
+		}
+		modelPath, modelType, width, height, horizon = model.Path, model.Type, model.ImgWidth, model.ImgHeight, model.Horizon
+		if steeringOutput == "" {
+			steeringOutput = model.SteeringOutput
+		}
+		if throttleOutput == "" {
+			throttleOutput = model.ThrottleOutput
+		}
+		annotations = model.Annotations
+		f.ociDigest = model.Digest
+	}
+
+	if f.imgWidth != 0 {
+		width = f.imgWidth
+	}
+	if f.imgHeight != 0 {
+		height = f.imgHeight
+	}
+	if f.horizon != 0 {
+		horizon = f.horizon
+	}
+	if width <= 0 || height <= 0 {
+		return nil, nil, fmt.Errorf("img-width and img-height are mandatory")
+	}
+
+	pre := steering.DefaultPreprocessing(width, height, horizon)
+	for _, setting := range steering.PreprocessingSettings {
+		if v, ok := annotations[setting]; ok {
+			if err := pre.Set(setting, v); err != nil {
+				return nil, nil, fmt.Errorf("bad oci annotation: %w", err)
+			}
+		}
+		if v, ok := f.preprocessing[setting]; ok {
+			if err := pre.Set(setting, v); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := pre.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid preprocessing: %w", err)
+	}
+
+	dec := steering.DefaultDecoding()
+	for _, setting := range steering.DecodingSettings {
+		if v, ok := annotations[setting]; ok {
+			if err := dec.Set(setting, v); err != nil {
+				return nil, nil, fmt.Errorf("bad oci annotation: %w", err)
+			}
+		}
+		if v, ok := f.decoding[setting]; ok {
+			if err := dec.Set(setting, v); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if err := dec.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid decoding: %w", err)
+	}
+
+	if f.ociRepository == "" {
+		zap.S().Infof("model path            : %v", modelPath)
+	} else {
+		zap.S().Infof("oci image model       : %v/%v:%v", f.ociRegistry, f.ociRepository, f.ociTag)
+		zap.S().Infof("oci image digest      : %v", f.ociDigest)
+	}
+	zap.S().Infof("model type            : %v", modelType)
+	zap.S().Infof("model for image width : %v", width)
+	zap.S().Infof("model for image height: %v", height)
+	zap.S().Infof("model with horizon    : %v", horizon)
+	zap.S().Infof("model preprocessing   : %v", pre.String())
+	if modelType == tools.ModelTypeCategorical {
+		zap.S().Infof("model decoding        : %v", dec.String())
+	}
+
+	eng, err := tflite.New(engine.ParseBackend(f.backend), f.threads, f.edgeVerbosity, f.edgeDevice, f.edgePool)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to init inference engine: %w", err)
+	}
+	return steering.NewModel(eng, modelType, modelPath, pre, dec, steeringOutput, throttleOutput), eng, nil
+}
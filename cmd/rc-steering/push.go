@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/oci"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"strings"
+)
+
+// runModel run model management commands
+func runModel(args []string) int {
+	if len(args) > 0 && args[0] == "push" {
+		return runModelPush(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s model push [flags] <model file>\n", os.Args[0])
+	return 1
+}
+
+// pushFlags describe model to push, settings not set are detected from model name
+type pushFlags struct {
+	registry, repository, tag      string
+	registryOptions                oci.RegistryOptions
+	modelType, category            string
+	imgWidth, imgHeight, horizon   int
+	steeringOutput, throttleOutput string
+	// annotations are preprocessing and decoding settings, by name
+	annotations map[string]string
+}
+
+func (f *pushFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.registry, "oci-model-registry", "", "oci registry where to push model")
+	fs.StringVar(&f.repository, "oci-model-repository", "", "oci repository where to push model")
+	fs.StringVar(&f.tag, "oci-model-tag", "latest", "oci tag of pushed model")
+	registerRegistryFlags(fs, &f.registryOptions)
+	fs.StringVar(&f.modelType, "type", "", "model type: 'categorical' or 'linear', detected from model name if not set")
+	fs.StringVar(&f.category, "category", "", "model category annotation")
+	fs.IntVar(&f.imgWidth, "img-width", 0, "image width expected by model, detected from model name if not set")
+	fs.IntVar(&f.imgHeight, "img-height", 0, "image height expected by model, detected from model name if not set")
+	fs.IntVar(&f.horizon, "horizon", -1, "upper zone to crop from image, detected from model name if not set")
+	fs.StringVar(&f.steeringOutput, "steering-output", "", "index or name of model output to use for steering")
+	fs.StringVar(&f.throttleOutput, "throttle-output", "", "index or name of model output to use for throttle")
+
+	f.annotations = make(map[string]string)
+	fs.Func("annotation", "preprocessing or decoding setting stored with model as '<name>=<value>', repeat flag for each setting: "+strings.Join(append(append([]string{}, steering.PreprocessingSettings...), steering.DecodingSettings...), ", "), func(v string) error {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return fmt.Errorf("bad annotation '%v', expected '<name>=<value>'", v)
+		}
+		f.annotations[name] = value
+		return nil
+	})
+}
+
+// model return model at path described by flags, settings not set are detected from model name
+func (f *pushFlags) model(path string) (oci.Model, error) {
+	model := oci.Model{
+		Path:           path,
+		Type:           tools.ParseModelType(f.modelType),
+		ImgWidth:       f.imgWidth,
+		ImgHeight:      f.imgHeight,
+		Horizon:        f.horizon,
+		SteeringOutput: f.steeringOutput,
+		ThrottleOutput: f.throttleOutput,
+		Annotations:    make(map[string]string),
+	}
+	if f.modelType != "" && model.Type == tools.ModelTypeUnknown {
+		return model, fmt.Errorf("unknown model type '%v'", f.modelType)
+	}
+	if model.Type == tools.ModelTypeUnknown || model.ImgWidth <= 0 || model.ImgHeight <= 0 || model.Horizon < 0 {
+		modelType, width, height, horizon, err := parseModelName(path)
+		if err != nil {
+			return model, fmt.Errorf("model configuration not set and unable to detect it from model name: %w", err)
+		}
+		if model.Type == tools.ModelTypeUnknown {
+			model.Type = modelType
+		}
+		if model.ImgWidth <= 0 {
+			model.ImgWidth = width
+		}
+		if model.ImgHeight <= 0 {
+			model.ImgHeight = height
+		}
+		if model.Horizon < 0 {
+			model.Horizon = horizon
+		}
+	}
+
+	// Check settings, as done when model is pulled
+	pre := steering.DefaultPreprocessing(model.ImgWidth, model.ImgHeight, model.Horizon)
+	dec := steering.DefaultDecoding()
+	for name, value := range f.annotations {
+		var err error
+		switch {
+		case contains(steering.PreprocessingSettings, name):
+			err = pre.Set(name, value)
+		case contains(steering.DecodingSettings, name):
+			err = dec.Set(name, value)
+		default:
+			err = fmt.Errorf("unknown setting '%v'", name)
+		}
+		if err != nil {
+			return model, err
+		}
+		model.Annotations[name] = value
+	}
+	if err := pre.Validate(); err != nil {
+		return model, fmt.Errorf("invalid preprocessing: %w", err)
+	}
+	if err := dec.Validate(); err != nil {
+		return model, fmt.Errorf("invalid decoding: %w", err)
+	}
+	if f.category != "" {
+		model.Annotations["category"] = f.category
+	}
+	return model, nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// runModelPush push a tflite model to an oci registry, with annotations expected by oci-model-* flags
+func runModelPush(args []string) int {
+	var pf pushFlags
+	logLevel := zapcore.InfoLevel
+
+	flags := flag.NewFlagSet("model push", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s model push [flags] <model file>\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Model type, image size and horizon are detected from 'model_<type>_<width>x<height>h<horizon>[_edgetpu].tflite' names when not set.")
+		flags.PrintDefaults()
+	}
+	pf.register(flags)
+	flags.Var(&logLevel, "log", "log level")
+	_ = flags.Parse(args)
+
+	if flags.NArg() != 1 || pf.registry == "" || pf.repository == "" || pf.tag == "" {
+		flags.Usage()
+		return 1
+	}
+	defer initLogger(logLevel)()
+
+	model, err := pf.model(flags.Arg(0))
+	if err != nil {
+		zap.L().Error("invalid model", zap.Error(err))
+		return 1
+	}
+	d, err := oci.PushOciImage(context.Background(), pf.registry, pf.repository, pf.tag, model, pf.registryOptions)
+	if err != nil {
+		zap.L().Error("unable to push model", zap.Error(err))
+		return 1
+	}
+	fmt.Printf("%v/%v@%v\n", pf.registry, pf.repository, d)
+	return 0
+}
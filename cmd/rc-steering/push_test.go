@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/oci"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"reflect"
+	"testing"
+)
+
+func Test_pushFlags_model(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   pushFlags
+		path    string
+		want    oci.Model
+		wantErr bool
+	}{
+		{
+			name:  "detected from name",
+			flags: pushFlags{horizon: -1, category: "steering"},
+			path:  "/tmp/model_categorical_160x120h20_edgetpu.tflite",
+			want:  oci.Model{Path: "/tmp/model_categorical_160x120h20_edgetpu.tflite", Type: tools.ModelTypeCategorical, ImgWidth: 160, ImgHeight: 120, Horizon: 20, Annotations: map[string]string{"category": "steering"}},
+		},
+		{
+			name:  "flags override name",
+			flags: pushFlags{modelType: "linear", imgWidth: 96, horizon: 0, annotations: map[string]string{"decoder": "expectation"}},
+			path:  "/tmp/model_categorical_160x120h20.tflite",
+			want:  oci.Model{Path: "/tmp/model_categorical_160x120h20.tflite", Type: tools.ModelTypeLinear, ImgWidth: 96, ImgHeight: 120, Horizon: 0, Annotations: map[string]string{"decoder": "expectation"}},
+		},
+		{
+			name:  "all flags set",
+			flags: pushFlags{modelType: "linear", imgWidth: 160, imgHeight: 120, horizon: 10, steeringOutput: "angle"},
+			path:  "/tmp/pilot.tflite",
+			want:  oci.Model{Path: "/tmp/pilot.tflite", Type: tools.ModelTypeLinear, ImgWidth: 160, ImgHeight: 120, Horizon: 10, SteeringOutput: "angle", Annotations: map[string]string{}},
+		},
+		{name: "unknown name", flags: pushFlags{horizon: -1}, path: "/tmp/pilot.tflite", wantErr: true},
+		{name: "unknown type", flags: pushFlags{modelType: "tree", horizon: -1}, path: "/tmp/model_linear_160x120h20.tflite", wantErr: true},
+		{name: "unknown setting", flags: pushFlags{horizon: -1, annotations: map[string]string{"color": "red"}}, path: "/tmp/model_linear_160x120h20.tflite", wantErr: true},
+		{name: "bad setting", flags: pushFlags{horizon: -1, annotations: map[string]string{"decoder": "random"}}, path: "/tmp/model_linear_160x120h20.tflite", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.flags.model(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("model() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("model() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
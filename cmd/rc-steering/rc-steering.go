@@ -5,15 +5,21 @@ import (
 	"flag"
 	"fmt"
 	"github.com/cyrilix/robocar-base/cli"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
 	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/metrics"
 	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/oci"
 	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
 	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"log"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 const (
@@ -21,32 +27,72 @@ const (
 )
 
 var (
-	modelNameRegex = regexp.MustCompile(".*model_(?P<type>(categorical)|(linear))_(?P<imgWidth>\\d+)x(?P<imgHeight>\\d+)h(?P<horizon>\\d+)_edgetpu.tflite$")
+	modelNameRegex = regexp.MustCompile(".*model_([a-z]+_)?(?P<type>(categorical)|(linear))_(?P<imgWidth>\\d+)x(?P<imgHeight>\\d+)h(?P<horizon>\\d+)(_edgetpu)?\\.tflite$")
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "infer":
+			os.Exit(runInfer(os.Args[2:]))
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "model":
+			os.Exit(runModel(os.Args[2:]))
+		}
+	}
+
 	var mqttBroker, username, password, clientId string
-	var cameraTopic, steeringTopic string
-	var modelPath, modelsDir, ociRegistry, ociRepository, ociTag string
-	var edgeVerbosity int
-	var imgWidth, imgHeight, horizon int
+	var cameraTopic, steeringTopic, throttleTopic string
+	var driveModeTopic string
+	var pilotOnly bool
+	var maxFrameAge, frameMaxGap time.Duration
+	var failSafeDeadline time.Duration
+	var failSafeMaxErrors int
+	var failSafeSteering, failSafeConfidence float64
+	var statusTopic string
+	var cameraQos int
+	var statusRetain bool
+	var mf modelFlags
+	var ff filterFlags
+	var ef ensembleFlags
+	var shadowSpec, shadowTopic string
+	var reloadTopic string
+	var modelWatchInterval time.Duration
+	var registryWatchInterval, registryWatchJitter time.Duration
+	var registryWatchTopic string
 
 	mqttQos := cli.InitIntFlag("MQTT_QOS", 0)
 	_, mqttRetain := os.LookupEnv("MQTT_RETAIN")
 
 	cli.InitMqttFlags(DefaultClientId, &mqttBroker, &username, &password, &clientId, &mqttQos, &mqttRetain)
 
-	flag.StringVar(&modelPath, "model", "", "path to model file")
-	flag.StringVar(&ociRegistry, "oci-model-registry", "", "oci registry where to fetch model")
-	flag.StringVar(&ociRepository, "oci-model-repository", "", "oci repository where to fetch model")
-	flag.StringVar(&ociTag, "oci-model-tag", "", "oci tag name for model to pull")
-	flag.StringVar(&modelsDir, "models-dir", "/tmp/robocar/models", "path where to store model file")
+	mf.register(flag.CommandLine)
+	ff.register(flag.CommandLine)
+	ef.register(flag.CommandLine)
+	flag.StringVar(&reloadTopic, "mqtt-topic-reload", os.Getenv("MQTT_TOPIC_RELOAD"), "Mqtt topic where any message reload model, use MQTT_TOPIC_RELOAD if args not set. Model is also reloaded on SIGHUP")
+	flag.DurationVar(&modelWatchInterval, "model-watch-interval", 0, "reload model when its file changes, checked at this interval, 0 to disable")
+	flag.DurationVar(&registryWatchInterval, "oci-watch-interval", 0, "poll oci-model-tag at this interval and switch to new models it references, 0 to disable")
+	flag.DurationVar(&registryWatchJitter, "oci-watch-jitter", 0, "maximum random delay added to oci-watch-interval, so that cars don't poll registry at the same time")
+	flag.StringVar(&registryWatchTopic, "mqtt-topic-oci-watch", os.Getenv("MQTT_TOPIC_OCI_WATCH"), "Mqtt topic where 'pause' and 'resume' messages control oci registry polling, use MQTT_TOPIC_OCI_WATCH if args not set")
+	flag.StringVar(&shadowSpec, "shadow-model", "", "candidate model run alongside primary model without affecting published steering: 'model=<path>' or 'oci=<registry>/<repository>:<tag>', engine settings are shared with primary model")
+	flag.StringVar(&shadowTopic, "mqtt-topic-shadow", os.Getenv("MQTT_TOPIC_SHADOW"), "Mqtt topic to publish shadow model steering, use MQTT_TOPIC_SHADOW if args not set")
 	flag.StringVar(&steeringTopic, "mqtt-topic-road", os.Getenv("MQTT_TOPIC_STEERING"), "Mqtt topic to publish road detection result, use MQTT_TOPIC_STEERING if args not set")
+	flag.StringVar(&throttleTopic, "mqtt-topic-throttle", os.Getenv("MQTT_TOPIC_THROTTLE"), "Mqtt topic to publish throttle result when model has a throttle output, use MQTT_TOPIC_THROTTLE if args not set")
+	flag.StringVar(&driveModeTopic, "mqtt-topic-drive-mode", os.Getenv("MQTT_TOPIC_DRIVE_MODE"), "Mqtt topic that contains drive mode value, use MQTT_TOPIC_DRIVE_MODE if args not set")
+	flag.BoolVar(&pilotOnly, "pilot-only", false, "pause inference while drive mode isn't PILOT, need mqtt-topic-drive-mode")
 	flag.StringVar(&cameraTopic, "mqtt-topic-camera", os.Getenv("MQTT_TOPIC_CAMERA"), "Mqtt topic that contains camera frame values, use MQTT_TOPIC_CAMERA if args not set")
-	flag.IntVar(&edgeVerbosity, "edge-verbosity", 0, "Edge TPU Verbosity")
-	flag.IntVar(&imgWidth, "img-width", 0, "image width expected by model")
-	flag.IntVar(&imgHeight, "img-height", 0, "image height expected by model")
-	flag.IntVar(&horizon, "horizon", 0, "upper zone to crop from image. Models expect size 'imgHeight - horizon'")
+	flag.DurationVar(&maxFrameAge, "max-frame-age", 0, "drop frames older than this duration when their processing start, 0 to disable")
+	flag.DurationVar(&frameMaxGap, "frame-max-gap", 500*time.Millisecond, "for models that expect many frames, reset frames history when 2 frames are created more than this duration apart, 0 to never reset")
+	flag.DurationVar(&failSafeDeadline, "failsafe-deadline", 0, "publish fail-safe steering when no inference succeeds within this duration, 0 to disable")
+	flag.IntVar(&failSafeMaxErrors, "failsafe-max-errors", 0, "publish fail-safe steering after this number of consecutive inference errors, 0 to disable")
+	flag.Float64Var(&failSafeSteering, "failsafe-steering", 0., "steering value published in degraded mode")
+	flag.Float64Var(&failSafeConfidence, "failsafe-confidence", 0., "steering confidence published in degraded mode")
+	flag.StringVar(&statusTopic, "mqtt-topic-status", os.Getenv("MQTT_TOPIC_STATUS"), "Mqtt topic to publish degraded mode status ('ok' or 'degraded'), use MQTT_TOPIC_STATUS if args not set")
+	flag.IntVar(&cameraQos, "mqtt-qos-camera", -1, "Qos to subscribe camera topic, old frames are useless so 0 is recommended, use mqtt qos if not set")
+	flag.BoolVar(&statusRetain, "mqtt-retain-status", true, "Retain status messages so that new subscribers know current mode")
 	logLevel := zap.LevelFlag("log", zap.InfoLevel, "log level")
 	flag.Parse()
 
@@ -55,73 +101,77 @@ func main() {
 		os.Exit(1)
 	}
 
-	config := zap.NewDevelopmentConfig()
-	config.Level = zap.NewAtomicLevelAt(*logLevel)
-	lgr, err := config.Build()
-	if err != nil {
-		log.Fatalf("unable to init logger: %v", err)
-	}
-	defer func() {
-		if err := lgr.Sync(); err != nil {
-			log.Printf("unable to Sync logger: %v\n", err)
-		}
-	}()
-	zap.ReplaceGlobals(lgr)
+	defer initLogger(*logLevel)()
 
 	cleanup := metrics.Init(context.Background())
 	defer cleanup()
-	if modelPath == "" && ociRepository == "" {
-		zap.L().Error("model path or oci image is mandatory")
+	if ef.enabled() {
+		if mf.path != "" || mf.ociRepository != "" {
+			zap.L().Error("invalid model configuration, ensemble-member is exclusive with model and oci-model-* flags")
+			flag.PrintDefaults()
+			os.Exit(1)
+		}
+	} else if err := mf.validate(); err != nil {
+		zap.L().Error("invalid model configuration", zap.Error(err))
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if registryWatchInterval > 0 && (mf.ociRepository == "" || strings.Contains(mf.ociRepository, "@") || strings.Contains(mf.ociTag, ":")) {
+		zap.L().Error("oci-watch-interval need a model pulled by tag")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
-	if modelPath != "" && ociRepository != "" {
-		zap.L().Error("model path and oci image are exclusives")
+	if mqttQos < 0 || mqttQos > 2 || cameraQos < -1 || cameraQos > 2 {
+		zap.L().Error("mqtt qos must be 0, 1 or 2")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if pilotOnly && driveModeTopic == "" {
+		zap.L().Error("pilot-only need mqtt-topic-drive-mode")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	var modelType tools.ModelType
-	var width, height, horizonFromName int
+	steeringFilter, err := ff.newFilter()
+	if err != nil {
+		zap.L().Error("invalid steering filter configuration", zap.Error(err))
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
 
-	if modelPath != "" {
-		modelType, width, height, horizonFromName, err = parseModelName(modelPath)
+	var model *steering.Model
+	var ensemble *steering.Ensemble
+	workers := 1
+	if ef.enabled() {
+		ensemble, err = ef.newEnsemble(context.Background(), mf)
 		if err != nil {
-			zap.S().Panicf("bad model name '%v', unable to detect configuration from name pattern: %v", modelPath, err)
+			zap.L().Fatal("unable to init ensemble", zap.Error(err))
 		}
 	} else {
-		ctx := context.Background()
-		modelPath, modelType, width, height, horizonFromName, err = oci.PullOciImage(ctx, ociRegistry, ociRepository, ociTag, modelsDir)
+		var eng engine.Engine
+		model, eng, err = mf.newModel(context.Background())
 		if err != nil {
-			zap.S().Panicf("bad model name '%v', unable to detect configuration from name pattern: %v", modelPath, err)
+			zap.L().Fatal("unable to init model", zap.Error(err))
+		}
+		if pool, ok := eng.(*engine.Pool); ok {
+			workers = pool.Size()
 		}
-
-	}
-
-	if imgWidth == 0 {
-		imgWidth = width
-	}
-	if imgHeight == 0 {
-		imgHeight = height
-	}
-	if horizonFromName == 0 {
-		horizon = horizonFromName
-	}
-	if imgWidth <= 0 || imgHeight <= 0 {
-		zap.L().Error("img-width and img-height are mandatory")
-		flag.PrintDefaults()
-		os.Exit(1)
 	}
 
-	if ociRepository == "" {
-		zap.S().Infof("model path            : %v", modelPath)
-	} else {
-		zap.S().Infof("oci image model       : %v/%v:%v", ociRegistry, ociRepository, ociTag)
+	var shadow *steering.Model
+	if shadowSpec != "" {
+		spec, err := parseMemberSpec(shadowSpec)
+		if err != nil {
+			zap.L().Fatal("invalid shadow model", zap.Error(err))
+		}
+		if spec.topic != "" {
+			shadowTopic = spec.topic
+		}
+		shadow, err = spec.newModel(context.Background(), mf)
+		if err != nil {
+			zap.L().Fatal("unable to init shadow model", zap.Error(err))
+		}
 	}
-	zap.S().Infof("model type            : %v", modelType)
-	zap.S().Infof("model for image width : %v", imgWidth)
-	zap.S().Infof("model for image height: %v", imgHeight)
-	zap.S().Infof("model with horizon    : %v", horizon)
 
 	client, err := cli.Connect(mqttBroker, username, password, clientId)
 	if err != nil {
@@ -129,10 +179,65 @@ func main() {
 	}
 	defer client.Disconnect(50)
 
-	p := steering.NewPart(client, modelType, modelPath, steeringTopic, cameraTopic, edgeVerbosity, imgWidth, imgHeight, horizon)
+	opts := []steering.Option{
+		steering.WithThrottleTopic(throttleTopic),
+		steering.WithQos(byte(mqttQos), mqttRetain),
+		steering.WithDriveMode(driveModeTopic, pilotOnly),
+		steering.WithMaxFrameAge(maxFrameAge),
+		steering.WithFrameGap(frameMaxGap),
+		steering.WithWorkers(workers),
+		steering.WithFailSafe(failSafeDeadline, failSafeMaxErrors, float32(failSafeSteering), float32(failSafeConfidence), statusTopic),
+	}
+	if cameraQos >= 0 {
+		opts = append(opts, steering.WithTopicQos(cameraTopic, byte(cameraQos), false))
+	}
+	if statusTopic != "" {
+		opts = append(opts, steering.WithTopicQos(statusTopic, byte(mqttQos), statusRetain))
+	}
+	if steeringFilter != nil {
+		opts = append(opts, steering.WithSteeringFilter(steeringFilter))
+	}
+	if shadow != nil {
+		opts = append(opts, steering.WithShadow(shadow, shadowTopic))
+	}
+	var p *steering.Part
+	var watcher *oci.Watcher
+	if registryWatchInterval > 0 {
+		base := mf
+		update := func(m oci.Model) error {
+			pinned := base.pinned(m.Digest)
+			return p.ReloadWith(func() (*steering.Model, error) {
+				m, _, err := pinned.newModel(context.Background())
+				return m, err
+			})
+		}
+		watcher = oci.NewWatcher(mf.ociRegistry, mf.ociRepository, mf.ociTag, mf.dir, mf.registryOptions, mf.ociDigest, registryWatchInterval, registryWatchJitter, update)
+		opts = append(opts, steering.WithRegistryWatcher(watcher, registryWatchTopic))
+	}
+	if ensemble == nil {
+		base := mf
+		loader := func() (*steering.Model, error) {
+			f := base
+			// Reload model rolled out by registry watcher, not the one tag references now
+			if watcher != nil && watcher.Current() != "" {
+				f = base.pinned(watcher.Current())
+			}
+			m, _, err := f.newModel(context.Background())
+			return m, err
+		}
+		opts = append(opts, steering.WithReload(loader, reloadTopic, modelWatchInterval))
+	}
+	if ensemble != nil {
+		p = steering.NewEnsemblePart(client, ensemble, steeringTopic, cameraTopic, opts...)
+	} else {
+		p = steering.NewPart(client, model, steeringTopic, cameraTopic, opts...)
+	}
 	defer p.Stop()
 
 	cli.HandleExit(p)
+	if ensemble == nil {
+		handleReload(p)
+	}
 
 	err = p.Start()
 	if err != nil {
@@ -140,6 +245,37 @@ func main() {
 	}
 }
 
+// handleReload reload model on SIGHUP
+func handleReload(p *steering.Part) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			zap.L().Info("SIGHUP received, reload model")
+			if err := p.Reload(); err != nil {
+				zap.L().Error("unable to reload model", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// initLogger replace global logger, returned function flush logs
+func initLogger(level zapcore.Level) func() {
+	config := zap.NewDevelopmentConfig()
+	config.Level = zap.NewAtomicLevelAt(level)
+	lgr, err := config.Build()
+	if err != nil {
+		log.Fatalf("unable to init logger: %v", err)
+	}
+	zap.ReplaceGlobals(lgr)
+	return func() {
+		if err := lgr.Sync(); err != nil {
+			log.Printf("unable to Sync logger: %v\n", err)
+		}
+	}
+}
+
 func parseModelName(modelPath string) (modelType tools.ModelType, imgWidth, imgHeight int, horizon int, err error) {
 	match := modelNameRegex.FindStringSubmatch(modelPath)
 
@@ -45,6 +45,15 @@ func Test_parseModelName(t *testing.T) {
 			wantHorizon:   10,
 			wantErr:       false,
 		},
+		{
+			name:          "edgetpu",
+			args:          args{modelPath: "/tmp/model_categorical_120x160h10_edgetpu.tflite"},
+			wantModelType: tools.ModelTypeCategorical,
+			wantImgWidth:  120,
+			wantImgHeight: 160,
+			wantHorizon:   10,
+			wantErr:       false,
+		},
 		{
 			name:          "bad-model",
 			args:          args{modelPath: "/tmp/model_123_120x160h10.tflite"},
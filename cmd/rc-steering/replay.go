@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/replay"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"image"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// runReplay run model on recorded frames and compare predictions with recorded steering
+func runReplay(args []string) int {
+	var mf modelFlags
+	var format, output string
+	var recordPerFile bool
+	var frameMaxGap time.Duration
+	logLevel := zapcore.InfoLevel
+
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [flags] <records file or directory>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	mf.register(flags)
+	flags.BoolVar(&recordPerFile, "record-per-file", false, "each file contains a single RecordMessage, else files are streams of length-delimited RecordMessage")
+	flags.DurationVar(&frameMaxGap, "frame-max-gap", 500*time.Millisecond, "for models that expect many frames, reset frames history when 2 recorded frames are created more than this duration apart, 0 to never reset")
+	flags.StringVar(&format, "format", "text", "report format: 'text' or 'json'")
+	flags.StringVar(&output, "output", "", "file where to write report, stdout if not set")
+	flags.Var(&logLevel, "log", "log level")
+	_ = flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1
+	}
+	if format != "text" && format != "json" {
+		fmt.Fprintf(flags.Output(), "unknown format '%v'\n", format)
+		flags.Usage()
+		return 1
+	}
+	defer initLogger(logLevel)()
+
+	model, _, err := mf.newModel(context.Background())
+	if err != nil {
+		zap.L().Error("unable to init model", zap.Error(err))
+		return 1
+	}
+	defer model.Close()
+	if err := model.Load(); err != nil {
+		zap.L().Error("unable to load model", zap.Error(err))
+		return 1
+	}
+
+	eval, err := replayRecords(model, flags.Args(), recordPerFile, frameMaxGap)
+	if err != nil {
+		zap.L().Error("unable to read records", zap.Error(err))
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			zap.L().Error("unable to create output file", zap.String("file", output), zap.Error(err))
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	report := replayReport{Model: model.Path(), Report: eval.Report()}
+	if format == "json" {
+		err = json.NewEncoder(out).Encode(report)
+	} else {
+		err = report.writeText(out)
+	}
+	if err != nil {
+		zap.L().Error("unable to write report", zap.Error(err))
+		return 1
+	}
+	return 0
+}
+
+// replayRecords run model on frames of records found in paths, in order, and compare predictions with recorded
+// steering. Models that expect many frames are fed with a history of recorded frames, by creation date
+func replayRecords(model *steering.Model, paths []string, recordPerFile bool, frameMaxGap time.Duration) (*replay.Evaluation, error) {
+	eval := replay.NewEvaluation(model.SteeringBins(), model.SteeringBin)
+	history := model.NewFrameHistory(frameMaxGap)
+	err := replay.Walk(paths, recordPerFile, func(file string, msg *events.RecordMessage) error {
+		if msg.GetFrame() == nil || msg.GetSteering() == nil {
+			zap.L().Debug("skip record without frame or steering", zap.String("file", file))
+			eval.Skip()
+			return nil
+		}
+		img, _, err := image.Decode(bytes.NewReader(msg.GetFrame().GetFrame()))
+		if err != nil {
+			zap.L().Warn("unable to decode frame",
+				zap.String("file", file),
+				zap.String("frame", msg.GetFrame().GetId().GetId()),
+				zap.Error(err),
+			)
+			eval.Skip()
+			return nil
+		}
+
+		start := time.Now()
+		prediction, err := model.PredictSeq(img, msg.GetFrame().GetId().GetCreatedAt().AsTime(), history)
+		latency := time.Since(start)
+		if err != nil {
+			zap.L().Error("unable to infer frame",
+				zap.String("file", file),
+				zap.String("frame", msg.GetFrame().GetId().GetId()),
+				zap.Error(err),
+			)
+			eval.Error()
+			return nil
+		}
+		eval.Add(prediction.Steering, msg.GetSteering().GetSteering(), latency)
+		return nil
+	})
+	return eval, err
+}
+
+type replayReport struct {
+	Model string `json:"model"`
+	replay.Report
+}
+
+func (r replayReport) writeText(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "model:\t%v\t\n", r.Model)
+	fmt.Fprintf(w, "samples:\t%d\t\n", r.Samples)
+	fmt.Fprintf(w, "skipped:\t%d\t\n", r.Skipped)
+	fmt.Fprintf(w, "errors:\t%d\t\n", r.Errors)
+	fmt.Fprintf(w, "mae:\t%.4f\t\n", r.MAE)
+	fmt.Fprintf(w, "rmse:\t%.4f\t\n", r.RMSE)
+	fmt.Fprintf(w, "latency (ms):\tmean %.2f\tp50 %.2f\tp90 %.2f\tp99 %.2f\tmax %.2f\t\n",
+		r.Latency.Mean, r.Latency.P50, r.Latency.P90, r.Latency.P99, r.Latency.Max)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if len(r.Confusion) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(out, "\nconfusion matrix (rows: recorded bin, columns: predicted bin):")
+	w = tabwriter.NewWriter(out, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "\t")
+	for i := range r.Confusion {
+		fmt.Fprintf(w, "%d\t", i)
+	}
+	fmt.Fprintln(w)
+	for i, row := range r.Confusion {
+		fmt.Fprintf(w, "%d\t", i)
+		for _, v := range row {
+			fmt.Fprintf(w, "%d\t", v)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
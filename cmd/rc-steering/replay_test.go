@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/replay"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/steering"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func solidRecord(t *testing.T, id string, y uint8, createdAt time.Time, steering float32) *events.RecordMessage {
+	img := image.NewGray(image.Rect(0, 0, 16, 12))
+	for i := range img.Pix {
+		img.Pix[i] = y
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("unable to encode frame: %v", err)
+	}
+	return &events.RecordMessage{
+		Frame:    &events.FrameMessage{Id: &events.FrameRef{Id: id, CreatedAt: timestamppb.New(createdAt)}, Frame: buf.Bytes()},
+		Steering: &events.SteeringMessage{Steering: steering, Confidence: 1.},
+	}
+}
+
+func Test_replayRecords_stacked(t *testing.T) {
+	const frames, width, height = 2, 16, 12
+	input := engine.Tensor{
+		Name:         "input",
+		Type:         engine.TensorTypeUInt8,
+		Shape:        []int{1, frames, height, width, 3},
+		Quantization: engine.Quantization{Scale: 1. / 255.},
+	}
+	output := engine.Tensor{
+		Name:         "angle",
+		Type:         engine.TensorTypeUInt8,
+		Shape:        []int{1, 15},
+		Quantization: engine.Quantization{Scale: 1. / 255.},
+	}
+	frameSize := input.ByteSize() / frames
+	// Steer right when last frame is white, left when it is black
+	eng := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.FromInput(func(inputs [][]byte) [][]byte {
+		b := make([]byte, 15)
+		if inputs[0][frameSize] > 128 {
+			b[14] = 255
+		} else {
+			b[0] = 255
+		}
+		return [][]byte{b}
+	}))
+	model := steering.NewModel(eng, tools.ModelTypeCategorical, "model.tflite", steering.DefaultPreprocessing(width, height, 0), steering.DefaultDecoding(), "", "")
+	if err := model.Load(); err != nil {
+		t.Fatalf("unable to load model: %v", err)
+	}
+	defer model.Close()
+
+	t0 := time.Now()
+	var buf bytes.Buffer
+	for _, r := range []*events.RecordMessage{
+		solidRecord(t, "1", 0, t0, -1.),
+		solidRecord(t, "2", 255, t0.Add(50*time.Millisecond), 1.),
+		solidRecord(t, "3", 0, t0.Add(100*time.Millisecond), -1.),
+	} {
+		if err := replay.Write(&buf, r); err != nil {
+			t.Fatalf("unable to write record: %v", err)
+		}
+	}
+	records := filepath.Join(t.TempDir(), "records")
+	if err := os.WriteFile(records, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("unable to write records: %v", err)
+	}
+
+	eval, err := replayRecords(model, []string{records}, false, time.Second)
+	if err != nil {
+		t.Fatalf("replayRecords() error = %v", err)
+	}
+	report := eval.Report()
+	if report.Samples != 3 || report.Errors != 0 || report.MAE != 0. {
+		t.Errorf("replayRecords() report = %+v, want 3 samples without error", report)
+	}
+	// Second call stacks black then white frames
+	calls := eng.Calls()
+	if len(calls) != 3 || calls[1][0][0] > 2 || calls[1][0][frameSize] < 253 {
+		t.Errorf("model not invoked on frames sequence")
+	}
+}
@@ -0,0 +1,156 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Engine runs a model on raw tensor buffers
+type Engine interface {
+	// Load model and allocate tensors
+	Load(modelPath string) error
+	// Inputs describe input tensors expected by loaded model
+	Inputs() []Tensor
+	// Outputs describe output tensors produced by loaded model
+	Outputs() []Tensor
+	// Run copy inputs into model, invoke it and copy results into outputs. Each buffer must have the size of
+	// its tensor
+	Run(inputs [][]byte, outputs [][]byte) error
+	// Close release all resources
+	Close()
+}
+
+// RunStats is the time spent in each step of a Run
+type RunStats struct {
+	CopyInputs  time.Duration
+	Invoke      time.Duration
+	CopyOutputs time.Duration
+}
+
+// Profiler is implemented by engines able to report time spent in each step of their last Run
+type Profiler interface {
+	LastRunStats() RunStats
+}
+
+type TensorType int
+
+// Values are the same as tflite.TensorType
+const (
+	TensorTypeNoType  TensorType = 0
+	TensorTypeFloat32 TensorType = 1
+	TensorTypeInt32   TensorType = 2
+	TensorTypeUInt8   TensorType = 3
+	TensorTypeInt64   TensorType = 4
+	TensorTypeInt16   TensorType = 7
+	TensorTypeInt8    TensorType = 9
+)
+
+func (t TensorType) String() string {
+	switch t {
+	case TensorTypeFloat32:
+		return "float32"
+	case TensorTypeInt32:
+		return "int32"
+	case TensorTypeUInt8:
+		return "uint8"
+	case TensorTypeInt64:
+		return "int64"
+	case TensorTypeInt16:
+		return "int16"
+	case TensorTypeInt8:
+		return "int8"
+	default:
+		return fmt.Sprintf("type(%d)", int(t))
+	}
+}
+
+// Size return number of bytes used by one element
+func (t TensorType) Size() int {
+	switch t {
+	case TensorTypeUInt8, TensorTypeInt8:
+		return 1
+	case TensorTypeInt16:
+		return 2
+	case TensorTypeFloat32, TensorTypeInt32:
+		return 4
+	case TensorTypeInt64:
+		return 8
+	default:
+		return 0
+	}
+}
+
+type Quantization struct {
+	Scale     float64
+	ZeroPoint int
+}
+
+type Tensor struct {
+	Name         string
+	Type         TensorType
+	Shape        []int
+	Quantization Quantization
+}
+
+// Len return number of elements
+func (t Tensor) Len() int {
+	n := 1
+	for _, d := range t.Shape {
+		n *= d
+	}
+	return n
+}
+
+// ByteSize return size of buffer needed to store tensor
+func (t Tensor) ByteSize() int {
+	return t.Len() * t.Type.Size()
+}
+
+func (t Tensor) String() string {
+	return fmt.Sprintf("%s %v%v (scale=%v, zero_point=%v)", t.Name, t.Type, t.Shape, t.Quantization.Scale, t.Quantization.ZeroPoint)
+}
+
+// NewBuffers allocate one buffer per tensor
+func NewBuffers(tensors []Tensor) [][]byte {
+	buffers := make([][]byte, len(tensors))
+	for i, t := range tensors {
+		buffers[i] = make([]byte, t.ByteSize())
+	}
+	return buffers
+}
+
+type Backend int
+
+const (
+	BackendUnknown Backend = iota
+	BackendAuto
+	BackendEdgeTPU
+	BackendCPU
+)
+
+func ParseBackend(s string) Backend {
+	switch strings.ToLower(s) {
+	case "auto":
+		return BackendAuto
+	case "edgetpu":
+		return BackendEdgeTPU
+	case "cpu":
+		return BackendCPU
+	default:
+		return BackendUnknown
+	}
+}
+
+func (b Backend) String() string {
+	switch b {
+	case BackendAuto:
+		return "auto"
+	case BackendEdgeTPU:
+		return "edgetpu"
+	case BackendCPU:
+		return "cpu"
+	default:
+		return "unknown"
+	}
+}
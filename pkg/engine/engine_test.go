@@ -0,0 +1,47 @@
+package engine
+
+import "testing"
+
+func TestParseBackend(t *testing.T) {
+	type args struct {
+		s string
+	}
+	tests := []struct {
+		name string
+		args args
+		want Backend
+	}{
+		{name: "auto", args: args{s: "auto"}, want: BackendAuto},
+		{name: "edgetpu", args: args{s: "edgetpu"}, want: BackendEdgeTPU},
+		{name: "edgetpu-upper", args: args{s: "EdgeTPU"}, want: BackendEdgeTPU},
+		{name: "cpu", args: args{s: "cpu"}, want: BackendCPU},
+		{name: "unknown", args: args{s: "gpu"}, want: BackendUnknown},
+		{name: "empty", args: args{s: ""}, want: BackendUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseBackend(tt.args.s); got != tt.want {
+				t.Errorf("ParseBackend() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTensor_ByteSize(t *testing.T) {
+	tests := []struct {
+		name   string
+		tensor Tensor
+		want   int
+	}{
+		{name: "uint8 image", tensor: Tensor{Type: TensorTypeUInt8, Shape: []int{1, 120, 160, 3}}, want: 57600},
+		{name: "float32 vector", tensor: Tensor{Type: TensorTypeFloat32, Shape: []int{1, 15}}, want: 60},
+		{name: "unknown type", tensor: Tensor{Type: TensorTypeNoType, Shape: []int{1, 15}}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.tensor.ByteSize(); got != tt.want {
+				t.Errorf("ByteSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package fake
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"sync"
+)
+
+// Script compute outputs returned by call number n (starting at 0) for inputs
+type Script func(n int, inputs [][]byte) ([][]byte, error)
+
+// Fixed return the same outputs on each call
+func Fixed(outputs ...[]byte) Script {
+	return func(_ int, _ [][]byte) ([][]byte, error) {
+		return outputs, nil
+	}
+}
+
+// Sequence return results[n] on call n, so one result per frame when each frame is inferred once. Calls after
+// the end of sequence fail
+func Sequence(results ...[][]byte) Script {
+	return func(n int, _ [][]byte) ([][]byte, error) {
+		if n >= len(results) {
+			return nil, fmt.Errorf("no more scripted result, call %d but only %d results", n, len(results))
+		}
+		return results[n], nil
+	}
+}
+
+// FromInput compute outputs from input pixels
+func FromInput(f func(inputs [][]byte) [][]byte) Script {
+	return func(_ int, inputs [][]byte) ([][]byte, error) {
+		return f(inputs), nil
+	}
+}
+
+// New instantiate an in-memory engine that describe inputs/outputs tensors and return results computed by script
+func New(inputs, outputs []engine.Tensor, script Script) *Engine {
+	return &Engine{
+		inputs:  inputs,
+		outputs: outputs,
+		script:  script,
+	}
+}
+
+type Engine struct {
+	muCalls sync.Mutex
+	inputs  []engine.Tensor
+	outputs []engine.Tensor
+	script  Script
+
+	// LoadErr is returned by Load when not nil
+	LoadErr error
+
+	modelPath string
+	calls     [][][]byte
+	closed    bool
+}
+
+func (e *Engine) Load(modelPath string) error {
+	if e.LoadErr != nil {
+		return e.LoadErr
+	}
+	e.modelPath = modelPath
+	return nil
+}
+
+func (e *Engine) Inputs() []engine.Tensor {
+	return e.inputs
+}
+
+func (e *Engine) Outputs() []engine.Tensor {
+	return e.outputs
+}
+
+func (e *Engine) Run(inputs [][]byte, outputs [][]byte) error {
+	if len(inputs) != len(e.inputs) {
+		return fmt.Errorf("bad inputs count, expected %d, got %d", len(e.inputs), len(inputs))
+	}
+	if len(outputs) != len(e.outputs) {
+		return fmt.Errorf("bad outputs count, expected %d, got %d", len(e.outputs), len(outputs))
+	}
+	for idx, b := range inputs {
+		if len(b) != e.inputs[idx].ByteSize() {
+			return fmt.Errorf("bad size for input %d, expected %d bytes, got %d", idx, e.inputs[idx].ByteSize(), len(b))
+		}
+	}
+
+	e.muCalls.Lock()
+	n := len(e.calls)
+	recorded := make([][]byte, len(inputs))
+	for idx, b := range inputs {
+		recorded[idx] = append([]byte(nil), b...)
+	}
+	e.calls = append(e.calls, recorded)
+	e.muCalls.Unlock()
+
+	results, err := e.script(n, recorded)
+	if err != nil {
+		return err
+	}
+	if len(results) != len(outputs) {
+		return fmt.Errorf("scripted result has %d outputs, expected %d", len(results), len(outputs))
+	}
+	for idx, b := range outputs {
+		if len(results[idx]) != len(b) {
+			return fmt.Errorf("bad size for scripted output %d, expected %d bytes, got %d", idx, len(b), len(results[idx]))
+		}
+		copy(b, results[idx])
+	}
+	return nil
+}
+
+func (e *Engine) Close() {
+	e.muCalls.Lock()
+	defer e.muCalls.Unlock()
+	e.closed = true
+}
+
+// ModelPath return path given to Load
+func (e *Engine) ModelPath() string {
+	return e.modelPath
+}
+
+// Calls return a copy of inputs received by each Run call
+func (e *Engine) Calls() [][][]byte {
+	e.muCalls.Lock()
+	defer e.muCalls.Unlock()
+	return append([][][]byte(nil), e.calls...)
+}
+
+// Closed return true if Close has been called
+func (e *Engine) Closed() bool {
+	e.muCalls.Lock()
+	defer e.muCalls.Unlock()
+	return e.closed
+}
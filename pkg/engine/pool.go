@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"sync"
+	"time"
+)
+
+// NewPool instantiate engine that spread inferences over members, each member being used by only one inference at
+// a time. Members are all loaded with the same model
+func NewPool(members ...PoolMember) (*Pool, error) {
+	if len(members) < 1 {
+		return nil, fmt.Errorf("empty engine pool")
+	}
+	return &Pool{
+		members: members,
+		free:    make(chan PoolMember, len(members)),
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+type PoolMember struct {
+	// Name identify member in logs and metrics
+	Name   string
+	Engine Engine
+}
+
+type Pool struct {
+	members []PoolMember
+	free    chan PoolMember
+
+	// ready is closed once all members are loaded and free to run inferences, done is closed by Close. muLoad
+	// guard both
+	muLoad sync.Mutex
+	ready  chan struct{}
+	done   chan struct{}
+
+	muStats      sync.Mutex
+	lastRunStats RunStats
+}
+
+// Size return number of members, that is the number of inferences that can run concurrently
+func (p *Pool) Size() int {
+	return len(p.members)
+}
+
+// Load model on all members, members are used by Run only once all of them are loaded. Pool can't be loaded twice
+func (p *Pool) Load(modelPath string) error {
+	p.muLoad.Lock()
+	defer p.muLoad.Unlock()
+	select {
+	case <-p.done:
+		return fmt.Errorf("engine pool closed")
+	case <-p.ready:
+		return fmt.Errorf("engine pool already loaded")
+	default:
+	}
+	for _, m := range p.members {
+		if err := m.Engine.Load(modelPath); err != nil {
+			return fmt.Errorf("unable to load model on '%v': %w", m.Name, err)
+		}
+	}
+	for _, m := range p.members {
+		p.free <- m
+	}
+	close(p.ready)
+	return nil
+}
+
+func (p *Pool) Inputs() []Tensor {
+	if len(p.members) == 0 {
+		return nil
+	}
+	return p.members[0].Engine.Inputs()
+}
+
+func (p *Pool) Outputs() []Tensor {
+	if len(p.members) == 0 {
+		return nil
+	}
+	return p.members[0].Engine.Outputs()
+}
+
+// Run wait for the least recently used free member and run inference on it. Run fails when pool isn't loaded or is
+// closed
+func (p *Pool) Run(inputs [][]byte, outputs [][]byte) error {
+	select {
+	case <-p.done:
+		return fmt.Errorf("engine pool closed")
+	default:
+	}
+	select {
+	case <-p.ready:
+	default:
+		return fmt.Errorf("engine pool not loaded")
+	}
+
+	var m PoolMember
+	select {
+	case m = <-p.free:
+	case <-p.done:
+		return fmt.Errorf("engine pool closed")
+	}
+	defer func() { p.free <- m }()
+
+	start := time.Now()
+	err := m.Engine.Run(inputs, outputs)
+	go metrics.DeviceInferenceDuration.Record(context.Background(), time.Since(start).Milliseconds(),
+		attribute.String("device", m.Name))
+	if err != nil {
+		return fmt.Errorf("inference failed on '%v': %w", m.Name, err)
+	}
+	if profiler, ok := m.Engine.(Profiler); ok {
+		p.muStats.Lock()
+		p.lastRunStats = profiler.LastRunStats()
+		p.muStats.Unlock()
+	}
+	return nil
+}
+
+// LastRunStats return time spent in each step of last successful Run, by the member that served it. Stats are empty
+// when members aren't profilers
+func (p *Pool) LastRunStats() RunStats {
+	p.muStats.Lock()
+	defer p.muStats.Unlock()
+	return p.lastRunStats
+}
+
+// Close release all members, inferences waiting for a free member fail
+func (p *Pool) Close() {
+	p.muLoad.Lock()
+	defer p.muLoad.Unlock()
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	close(p.done)
+	for _, m := range p.members {
+		m.Engine.Close()
+	}
+}
@@ -0,0 +1,193 @@
+package engine_test
+
+import (
+	"errors"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"testing"
+	"time"
+)
+
+var (
+	input  = engine.Tensor{Name: "input", Type: engine.TensorTypeUInt8, Shape: []int{1, 4}}
+	output = engine.Tensor{Name: "output", Type: engine.TensorTypeUInt8, Shape: []int{1, 1}}
+)
+
+func TestPool_Run(t *testing.T) {
+	usb := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{1}))
+	pci := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{2}))
+	p, err := engine.NewPool(engine.PoolMember{Name: "usb", Engine: usb}, engine.PoolMember{Name: "pci", Engine: pci})
+	if err != nil {
+		t.Fatalf("NewPool() unexpected error: %v", err)
+	}
+	if err := p.Load("model.tflite"); err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+
+	var results []byte
+	for i := 0; i < 4; i++ {
+		outputs := engine.NewBuffers(p.Outputs())
+		if err := p.Run([][]byte{make([]byte, 4)}, outputs); err != nil {
+			t.Fatalf("Run() unexpected error: %v", err)
+		}
+		results = append(results, outputs[0][0])
+	}
+
+	if len(usb.Calls()) != 2 || len(pci.Calls()) != 2 {
+		t.Errorf("Run() calls not spread over members, usb: %d, pci: %d", len(usb.Calls()), len(pci.Calls()))
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i] == results[i-1] {
+			t.Errorf("Run() same member used twice in a row: %v", results)
+		}
+	}
+
+	if err := p.Load("model.tflite"); err == nil {
+		t.Errorf("Load() of loaded pool, want error")
+	}
+
+	p.Close()
+	if !usb.Closed() || !pci.Closed() {
+		t.Errorf("Close() members not closed")
+	}
+}
+
+func TestPool_Load(t *testing.T) {
+	ok := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{1}))
+	ko := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{1}))
+	ko.LoadErr = errors.New("device unavailable")
+
+	tests := []struct {
+		name    string
+		members []engine.PoolMember
+		wantErr bool
+	}{
+		{name: "loaded", members: []engine.PoolMember{{Name: "ok", Engine: ok}}},
+		{name: "member failure", members: []engine.PoolMember{{Name: "ok", Engine: ok}, {Name: "ko", Engine: ko}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := engine.NewPool(tt.members...)
+			if err != nil {
+				t.Fatalf("NewPool() unexpected error: %v", err)
+			}
+			err = p.Load("model.tflite")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPool_Load_retry(t *testing.T) {
+	ok := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{1}))
+	flaky := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{2}))
+	flaky.LoadErr = errors.New("device unavailable")
+	p, err := engine.NewPool(engine.PoolMember{Name: "ok", Engine: ok}, engine.PoolMember{Name: "flaky", Engine: flaky})
+	if err != nil {
+		t.Fatalf("NewPool() unexpected error: %v", err)
+	}
+	if err := p.Load("model.tflite"); err == nil {
+		t.Fatalf("Load() with failing member, want error")
+	}
+
+	// Members loaded before failure must not be used
+	flaky.LoadErr = nil
+	if err := p.Load("model.tflite"); err != nil {
+		t.Fatalf("Load() retry unexpected error: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := p.Run([][]byte{make([]byte, 4)}, engine.NewBuffers(p.Outputs())); err != nil {
+			t.Fatalf("Run() unexpected error: %v", err)
+		}
+	}
+	if len(ok.Calls()) != 2 || len(flaky.Calls()) != 2 {
+		t.Errorf("Run() calls not spread over members, ok: %d, flaky: %d", len(ok.Calls()), len(flaky.Calls()))
+	}
+}
+
+func TestPool_Run_notReady(t *testing.T) {
+	newPool := func(t *testing.T) *engine.Pool {
+		eng := fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{1}))
+		p, err := engine.NewPool(engine.PoolMember{Name: "usb", Engine: eng})
+		if err != nil {
+			t.Fatalf("NewPool() unexpected error: %v", err)
+		}
+		return p
+	}
+	tests := []struct {
+		name string
+		init func(t *testing.T, p *engine.Pool)
+	}{
+		{name: "not loaded", init: func(*testing.T, *engine.Pool) {}},
+		{name: "closed", init: func(t *testing.T, p *engine.Pool) {
+			if err := p.Load("model.tflite"); err != nil {
+				t.Fatalf("Load() unexpected error: %v", err)
+			}
+			p.Close()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPool(t)
+			tt.init(t, p)
+
+			done := make(chan error, 1)
+			go func() { done <- p.Run([][]byte{make([]byte, 4)}, engine.NewBuffers([]engine.Tensor{output})) }()
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Errorf("Run() want error")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Run() blocked")
+			}
+		})
+	}
+}
+
+// profiledEngine report fixed stats for each Run
+type profiledEngine struct {
+	*fake.Engine
+	stats engine.RunStats
+}
+
+func (e *profiledEngine) LastRunStats() engine.RunStats {
+	return e.stats
+}
+
+func TestPool_LastRunStats(t *testing.T) {
+	usb := &profiledEngine{
+		Engine: fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{1})),
+		stats:  engine.RunStats{Invoke: time.Millisecond},
+	}
+	pci := &profiledEngine{
+		Engine: fake.New([]engine.Tensor{input}, []engine.Tensor{output}, fake.Fixed([]byte{2})),
+		stats:  engine.RunStats{Invoke: 2 * time.Millisecond},
+	}
+	p, err := engine.NewPool(engine.PoolMember{Name: "usb", Engine: usb}, engine.PoolMember{Name: "pci", Engine: pci})
+	if err != nil {
+		t.Fatalf("NewPool() unexpected error: %v", err)
+	}
+	if err := p.Load("model.tflite"); err != nil {
+		t.Fatalf("Load() unexpected error: %v", err)
+	}
+	var _ engine.Profiler = p
+
+	want := map[byte]engine.RunStats{1: usb.stats, 2: pci.stats}
+	for i := 0; i < 4; i++ {
+		outputs := engine.NewBuffers(p.Outputs())
+		if err := p.Run([][]byte{make([]byte, 4)}, outputs); err != nil {
+			t.Fatalf("Run() unexpected error: %v", err)
+		}
+		if got := p.LastRunStats(); got != want[outputs[0][0]] {
+			t.Errorf("LastRunStats() = %+v, want %+v", got, want[outputs[0][0]])
+		}
+	}
+}
+
+func TestNewPool_empty(t *testing.T) {
+	if _, err := engine.NewPool(); err == nil {
+		t.Errorf("NewPool() without member, want error")
+	}
+}
@@ -0,0 +1,91 @@
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Quantize encode real values into tensor buffer b, according to tensor type and quantization parameters.
+// Integer tensors without quantization parameters store values as is
+func (t Tensor) Quantize(values []float32, b []byte) error {
+	if len(values) != t.Len() {
+		return fmt.Errorf("bad values count for tensor %v, expected %d, got %d", t.Name, t.Len(), len(values))
+	}
+	if len(b) != t.ByteSize() {
+		return fmt.Errorf("bad buffer size for tensor %v, expected %d bytes, got %d", t.Name, t.ByteSize(), len(b))
+	}
+
+	switch t.Type {
+	case TensorTypeFloat32:
+		for i, v := range values {
+			binary.LittleEndian.PutUint32(b[i*4:], math.Float32bits(v))
+		}
+	case TensorTypeUInt8:
+		for i, v := range values {
+			b[i] = uint8(t.quantize(v, 0, math.MaxUint8))
+		}
+	case TensorTypeInt8:
+		for i, v := range values {
+			b[i] = uint8(int8(t.quantize(v, math.MinInt8, math.MaxInt8)))
+		}
+	default:
+		return fmt.Errorf("unsupported type %v for tensor %v", t.Type, t.Name)
+	}
+	return nil
+}
+
+// Dequantize decode tensor buffer b into real values
+func (t Tensor) Dequantize(b []byte, values []float32) error {
+	if len(values) != t.Len() {
+		return fmt.Errorf("bad values count for tensor %v, expected %d, got %d", t.Name, t.Len(), len(values))
+	}
+	if len(b) != t.ByteSize() {
+		return fmt.Errorf("bad buffer size for tensor %v, expected %d bytes, got %d", t.Name, t.ByteSize(), len(b))
+	}
+
+	switch t.Type {
+	case TensorTypeFloat32:
+		for i := range values {
+			values[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+		}
+	case TensorTypeUInt8:
+		for i := range values {
+			values[i] = t.dequantize(int(b[i]))
+		}
+	case TensorTypeInt8:
+		for i := range values {
+			values[i] = t.dequantize(int(int8(b[i])))
+		}
+	default:
+		return fmt.Errorf("unsupported type %v for tensor %v", t.Type, t.Name)
+	}
+	return nil
+}
+
+// Quantized return true if tensor has quantization parameters
+func (t Tensor) Quantized() bool {
+	return t.Quantization.Scale != 0
+}
+
+func (t Tensor) quantize(v float32, min, max int) int {
+	q := float64(v)
+	if t.Quantized() {
+		q = q/t.Quantization.Scale + float64(t.Quantization.ZeroPoint)
+	}
+	q = math.Round(q)
+	if q < float64(min) {
+		return min
+	}
+	if q > float64(max) {
+		return max
+	}
+	return int(q)
+}
+
+func (t Tensor) dequantize(q int) float32 {
+	if !t.Quantized() {
+		return float32(q)
+	}
+	return float32(float64(q-t.Quantization.ZeroPoint) * t.Quantization.Scale)
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTensor_Quantize(t *testing.T) {
+	tests := []struct {
+		name    string
+		tensor  Tensor
+		values  []float32
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name:   "uint8 normalized",
+			tensor: Tensor{Type: TensorTypeUInt8, Shape: []int{3}, Quantization: Quantization{Scale: 1. / 255., ZeroPoint: 0}},
+			values: []float32{0., 0.5, 1.},
+			want:   []byte{0, 128, 255},
+		},
+		{
+			name:   "uint8 with zero point",
+			tensor: Tensor{Type: TensorTypeUInt8, Shape: []int{3}, Quantization: Quantization{Scale: 1. / 128., ZeroPoint: 128}},
+			values: []float32{-1., 0., 2.},
+			want:   []byte{0, 128, 255},
+		},
+		{
+			name:   "uint8 without quantization",
+			tensor: Tensor{Type: TensorTypeUInt8, Shape: []int{2}},
+			values: []float32{12, 300},
+			want:   []byte{12, 255},
+		},
+		{
+			name:   "int8",
+			tensor: Tensor{Type: TensorTypeInt8, Shape: []int{3}, Quantization: Quantization{Scale: 1. / 255., ZeroPoint: -128}},
+			values: []float32{0., 1., 2.},
+			want:   []byte{0x80, 0x7f, 0x7f},
+		},
+		{
+			name:   "float32",
+			tensor: Tensor{Type: TensorTypeFloat32, Shape: []int{1}},
+			values: []float32{1.},
+			want:   []byte{0x00, 0x00, 0x80, 0x3f},
+		},
+		{
+			name:    "bad values count",
+			tensor:  Tensor{Type: TensorTypeUInt8, Shape: []int{2}},
+			values:  []float32{1.},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported type",
+			tensor:  Tensor{Type: TensorTypeInt64, Shape: []int{1}},
+			values:  []float32{1.},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := make([]byte, tt.tensor.ByteSize())
+			err := tt.tensor.Quantize(tt.values, b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Quantize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(b, tt.want) {
+				t.Errorf("Quantize() = %v, want %v", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestTensor_Dequantize(t *testing.T) {
+	tests := []struct {
+		name    string
+		tensor  Tensor
+		b       []byte
+		want    []float32
+		wantErr bool
+	}{
+		{
+			name:   "uint8 with zero point",
+			tensor: Tensor{Type: TensorTypeUInt8, Shape: []int{3}, Quantization: Quantization{Scale: 1. / 128., ZeroPoint: 128}},
+			b:      []byte{0, 128, 192},
+			want:   []float32{-1., 0., 0.5},
+		},
+		{
+			name:   "uint8 without quantization",
+			tensor: Tensor{Type: TensorTypeUInt8, Shape: []int{2}},
+			b:      []byte{0, 255},
+			want:   []float32{0, 255},
+		},
+		{
+			name:   "int8",
+			tensor: Tensor{Type: TensorTypeInt8, Shape: []int{2}, Quantization: Quantization{Scale: 0.5, ZeroPoint: -128}},
+			b:      []byte{0x80, 0x00},
+			want:   []float32{0., 64.},
+		},
+		{
+			name:   "float32",
+			tensor: Tensor{Type: TensorTypeFloat32, Shape: []int{1}},
+			b:      []byte{0x00, 0x00, 0x80, 0x3f},
+			want:   []float32{1.},
+		},
+		{
+			name:    "bad buffer size",
+			tensor:  Tensor{Type: TensorTypeFloat32, Shape: []int{2}},
+			b:       []byte{0x00, 0x00, 0x80, 0x3f},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := make([]float32, tt.tensor.Len())
+			err := tt.tensor.Dequantize(tt.b, values)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Dequantize() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("Dequantize() = %v, want %v", values, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package tflite
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	gotflite "github.com/mattn/go-tflite"
+	"github.com/mattn/go-tflite/delegates"
+	"github.com/mattn/go-tflite/delegates/edgetpu"
+	"go.uber.org/zap"
+	"time"
+)
+
+// DefaultNumThreads is the number of threads used by cpu kernels when not set
+const DefaultNumThreads = 4
+
+// New instantiate engine for backend. With auto backend, Edge TPU is used if a device is found, cpu otherwise. Cpu
+// kernels use numThreads threads, DefaultNumThreads if not positive.
+//
+// deviceSelector pin Edge TPU device to use: 'usb' or 'pci' select by type, 'usb:<path>' or 'pci:<path>' by type and
+// path, any other non-empty value by path. When pool is true, frames are spread over all selected devices, else only
+// the first one is used.
+func New(backend engine.Backend, numThreads int, edgeVerbosity int, deviceSelector string, pool bool) (engine.Engine, error) {
+	switch backend {
+	case engine.BackendCPU:
+		return NewCPU(numThreads), nil
+	case engine.BackendEdgeTPU, engine.BackendAuto:
+		devices, err := edgetpu.DeviceList()
+		if err != nil && backend == engine.BackendEdgeTPU {
+			return nil, fmt.Errorf("could not get EdgeTPU devices: %w", err)
+		}
+		zap.S().Infof("find %d edgetpu devices", len(devices))
+		for _, d := range devices {
+			zap.S().Infof("edgetpu device: %v", deviceName(d))
+		}
+		devices = selectDevices(devices, deviceSelector)
+		if len(devices) == 0 {
+			if backend == engine.BackendEdgeTPU {
+				return nil, fmt.Errorf("no edge TPU devices found matching '%v'", deviceSelector)
+			}
+			zap.S().Warnw("no edge TPU devices found, fallback to cpu", "selector", deviceSelector, "error", err)
+			return NewCPU(numThreads), nil
+		}
+
+		// Print the EdgeTPU version
+		edgetpuVersion, err := edgetpu.Version()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get EdgeTPU version: %w", err)
+		}
+		zap.S().Infof("EdgeTPU Version: %s", edgetpuVersion)
+		edgetpu.Verbosity(edgeVerbosity)
+
+		if !pool {
+			return NewEdgeTPU(devices[0], numThreads), nil
+		}
+		members := make([]engine.PoolMember, 0, len(devices))
+		for _, d := range devices {
+			members = append(members, engine.PoolMember{Name: deviceName(d), Engine: NewEdgeTPU(d, numThreads)})
+		}
+		return engine.NewPool(members...)
+	default:
+		return nil, fmt.Errorf("unsupported backend '%v'", backend)
+	}
+}
+
+func deviceTypeName(t edgetpu.DeviceType) string {
+	switch t {
+	case edgetpu.TypeApexPCI:
+		return "pci"
+	case edgetpu.TypeApexUSB:
+		return "usb"
+	default:
+		return "unknown"
+	}
+}
+
+func deviceName(d edgetpu.Device) string {
+	return deviceTypeName(d.Type) + ":" + d.Path
+}
+
+func selectDevices(devices []edgetpu.Device, selector string) []edgetpu.Device {
+	if selector == "" {
+		return devices
+	}
+	var selected []edgetpu.Device
+	for _, d := range devices {
+		if selector == deviceTypeName(d.Type) || selector == deviceName(d) || selector == d.Path {
+			selected = append(selected, d)
+		}
+	}
+	return selected
+}
+
+// NewCPU instantiate engine that run model with tflite cpu kernels
+func NewCPU(numThreads int) *Interpreter {
+	return &Interpreter{numThreads: numThreads}
+}
+
+// NewEdgeTPU instantiate engine that delegate model execution to Edge TPU device
+func NewEdgeTPU(device edgetpu.Device, numThreads int) *Interpreter {
+	return &Interpreter{device: &device, numThreads: numThreads}
+}
+
+type Interpreter struct {
+	device     *edgetpu.Device
+	numThreads int
+
+	delegate    delegates.Delegater
+	options     *gotflite.InterpreterOptions
+	interpreter *gotflite.Interpreter
+	model       *gotflite.Model
+
+	inputs  []engine.Tensor
+	outputs []engine.Tensor
+
+	lastRunStats engine.RunStats
+}
+
+func (i *Interpreter) Load(modelPath string) error {
+	i.model = gotflite.NewModelFromFile(modelPath)
+	if i.model == nil {
+		return fmt.Errorf("cannot load model %v", modelPath)
+	}
+
+	i.options = gotflite.NewInterpreterOptions()
+	numThreads := i.numThreads
+	if numThreads <= 0 {
+		numThreads = DefaultNumThreads
+	}
+	i.options.SetNumThread(numThreads)
+	i.options.SetErrorReporter(func(msg string, userData interface{}) {
+		zap.S().Errorw(msg,
+			"userData", userData,
+		)
+	}, nil)
+
+	if i.device != nil {
+		zap.S().Infow("configure edgetpu",
+			"path", i.device.Path,
+			"type", uint32(i.device.Type),
+		)
+		i.delegate = edgetpu.New(*i.device)
+		if i.delegate == nil {
+			return fmt.Errorf("unable to create new EdgeTpu delegate")
+		}
+		i.options.AddDelegate(i.delegate)
+	}
+
+	i.interpreter = gotflite.NewInterpreter(i.model, i.options)
+	if i.interpreter == nil {
+		return fmt.Errorf("cannot create interpreter")
+	}
+
+	status := i.interpreter.AllocateTensors()
+	if status != gotflite.OK {
+		return fmt.Errorf("tensor allocate failed: %v", status)
+	}
+
+	i.inputs = make([]engine.Tensor, i.interpreter.GetInputTensorCount())
+	for idx := range i.inputs {
+		i.inputs[idx] = describe(i.interpreter.GetInputTensor(idx))
+		zap.S().Infof("input tensor %d : %v", idx, i.inputs[idx])
+	}
+	i.outputs = make([]engine.Tensor, i.interpreter.GetOutputTensorCount())
+	for idx := range i.outputs {
+		i.outputs[idx] = describe(i.interpreter.GetOutputTensor(idx))
+		zap.S().Infof("output tensor %d: %v", idx, i.outputs[idx])
+	}
+	return nil
+}
+
+func (i *Interpreter) Inputs() []engine.Tensor {
+	return i.inputs
+}
+
+func (i *Interpreter) Outputs() []engine.Tensor {
+	return i.outputs
+}
+
+func (i *Interpreter) Run(inputs [][]byte, outputs [][]byte) error {
+	if len(inputs) != len(i.inputs) {
+		return fmt.Errorf("bad inputs count, expected %d, got %d", len(i.inputs), len(inputs))
+	}
+	if len(outputs) != len(i.outputs) {
+		return fmt.Errorf("bad outputs count, expected %d, got %d", len(i.outputs), len(outputs))
+	}
+
+	start := time.Now()
+	for idx, b := range inputs {
+		if len(b) != i.inputs[idx].ByteSize() {
+			return fmt.Errorf("bad size for input %d, expected %d bytes, got %d", idx, i.inputs[idx].ByteSize(), len(b))
+		}
+		status := i.interpreter.GetInputTensor(idx).CopyFromBuffer(b)
+		if status != gotflite.OK {
+			return fmt.Errorf("input copy from buffer failed: %v", status)
+		}
+	}
+
+	copied := time.Now()
+	status := i.interpreter.Invoke()
+	if status != gotflite.OK {
+		return fmt.Errorf("invoke failed: %v", status)
+	}
+	invoked := time.Now()
+
+	for idx, b := range outputs {
+		if len(b) != i.outputs[idx].ByteSize() {
+			return fmt.Errorf("bad size for output %d, expected %d bytes, got %d", idx, i.outputs[idx].ByteSize(), len(b))
+		}
+		status = i.interpreter.GetOutputTensor(idx).CopyToBuffer(b)
+		if status != gotflite.OK {
+			return fmt.Errorf("output copy to buffer failed: %v", status)
+		}
+	}
+	i.lastRunStats = engine.RunStats{
+		CopyInputs:  copied.Sub(start),
+		Invoke:      invoked.Sub(copied),
+		CopyOutputs: time.Since(invoked),
+	}
+	return nil
+}
+
+// LastRunStats return time spent in each step of last successful Run
+func (i *Interpreter) LastRunStats() engine.RunStats {
+	return i.lastRunStats
+}
+
+func (i *Interpreter) Close() {
+	if i.interpreter != nil {
+		i.interpreter.Delete()
+		i.interpreter = nil
+	}
+	if i.delegate != nil {
+		i.delegate.Delete()
+		i.delegate = nil
+	}
+	if i.options != nil {
+		i.options.Delete()
+		i.options = nil
+	}
+	if i.model != nil {
+		i.model.Delete()
+		i.model = nil
+	}
+}
+
+func describe(t *gotflite.Tensor) engine.Tensor {
+	q := t.QuantizationParams()
+	return engine.Tensor{
+		Name:  t.Name(),
+		Type:  engine.TensorType(t.Type()),
+		Shape: t.Shape(),
+		Quantization: engine.Quantization{
+			Scale:     q.Scale,
+			ZeroPoint: q.ZeroPoint,
+		},
+	}
+}
@@ -0,0 +1,147 @@
+package filter
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Filter smooth values of successive frames. Filters aren't safe for concurrent use
+type Filter interface {
+	// Apply return filtered value of v, produced for a frame created at date at
+	Apply(v float32, at time.Time) float32
+	// Reset forget previous values
+	Reset()
+}
+
+// Chain apply filters in order
+func Chain(filters ...Filter) Filter {
+	return chain(filters)
+}
+
+type chain []Filter
+
+func (c chain) Apply(v float32, at time.Time) float32 {
+	for _, f := range c {
+		v = f.Apply(v, at)
+	}
+	return v
+}
+
+func (c chain) Reset() {
+	for _, f := range c {
+		f.Reset()
+	}
+}
+
+// NewEMA compute exponential moving average, alpha in ]0, 1] is the weight of the new value
+func NewEMA(alpha float32) *EMA {
+	return &EMA{alpha: alpha}
+}
+
+type EMA struct {
+	alpha float32
+	value float32
+	init  bool
+}
+
+func (e *EMA) Apply(v float32, _ time.Time) float32 {
+	if !e.init {
+		e.value, e.init = v, true
+		return v
+	}
+	e.value = e.alpha*v + (1-e.alpha)*e.value
+	return e.value
+}
+
+func (e *EMA) Reset() {
+	e.init = false
+}
+
+// NewMedian return median of n last values
+func NewMedian(n int) *Median {
+	return &Median{values: make([]float32, 0, n), sorted: make([]float32, 0, n), n: n}
+}
+
+type Median struct {
+	n      int
+	values []float32
+	next   int
+	// sorted is reused to compute median
+	sorted []float32
+}
+
+func (m *Median) Apply(v float32, _ time.Time) float32 {
+	if len(m.values) < m.n {
+		m.values = append(m.values, v)
+	} else {
+		m.values[m.next] = v
+		m.next = (m.next + 1) % m.n
+	}
+
+	m.sorted = append(m.sorted[:0], m.values...)
+	sort.Slice(m.sorted, func(i, j int) bool { return m.sorted[i] < m.sorted[j] })
+	l := len(m.sorted)
+	if l%2 == 1 {
+		return m.sorted[l/2]
+	}
+	return (m.sorted[l/2-1] + m.sorted[l/2]) / 2
+}
+
+func (m *Median) Reset() {
+	m.values = m.values[:0]
+	m.next = 0
+}
+
+// NewRateLimit limit value change to maxRate by second
+func NewRateLimit(maxRate float32) *RateLimit {
+	return &RateLimit{maxRate: maxRate}
+}
+
+type RateLimit struct {
+	maxRate float32
+	value   float32
+	last    time.Time
+	init    bool
+}
+
+func (r *RateLimit) Apply(v float32, at time.Time) float32 {
+	if !r.init || !at.After(r.last) {
+		// Without elapsed time, no change can be bounded
+		if !r.init {
+			r.value, r.last, r.init = v, at, true
+		}
+		return r.value
+	}
+	maxDelta := r.maxRate * float32(at.Sub(r.last).Seconds())
+	delta := float32(math.Max(math.Min(float64(v-r.value), float64(maxDelta)), float64(-maxDelta)))
+	r.value += delta
+	r.last = at
+	return r.value
+}
+
+func (r *RateLimit) Reset() {
+	r.init = false
+}
+
+// NewDeadband keep previous value while new value differ by less than width
+func NewDeadband(width float32) *Deadband {
+	return &Deadband{width: width}
+}
+
+type Deadband struct {
+	width float32
+	value float32
+	init  bool
+}
+
+func (d *Deadband) Apply(v float32, _ time.Time) float32 {
+	if !d.init || math.Abs(float64(v-d.value)) >= float64(d.width) {
+		d.value, d.init = v, true
+	}
+	return d.value
+}
+
+func (d *Deadband) Reset() {
+	d.init = false
+}
@@ -0,0 +1,77 @@
+package filter
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// apply values at 100ms interval
+func apply(f Filter, values ...float32) []float32 {
+	t0 := time.Unix(0, 0)
+	result := make([]float32, len(values))
+	for i, v := range values {
+		result[i] = f.Apply(v, t0.Add(time.Duration(i)*100*time.Millisecond))
+	}
+	return result
+}
+
+func equals(a, b []float32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > 1e-6 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		values []float32
+		want   []float32
+	}{
+		{name: "ema", filter: NewEMA(0.5), values: []float32{1., 0., 0., 1.}, want: []float32{1., 0.5, 0.25, 0.625}},
+		{name: "ema without smoothing", filter: NewEMA(1.), values: []float32{1., 0., -1.}, want: []float32{1., 0., -1.}},
+		{name: "median", filter: NewMedian(3), values: []float32{0., 1., -1., 0.5, 0.5, 1.}, want: []float32{0., 0.5, 0., 0.5, 0.5, 0.5}},
+		{name: "median remove spike", filter: NewMedian(3), values: []float32{0.2, 0.2, 1., 0.2, 0.2}, want: []float32{0.2, 0.2, 0.2, 0.2, 0.2}},
+		{name: "rate limit", filter: NewRateLimit(2.), values: []float32{0., 1., 1., -1., -1.}, want: []float32{0., 0.2, 0.4, 0.2, 0.}},
+		{name: "rate limit small changes", filter: NewRateLimit(2.), values: []float32{0., 0.1, 0.}, want: []float32{0., 0.1, 0.}},
+		{name: "deadband", filter: NewDeadband(0.1), values: []float32{0., 0.05, -0.05, 0.15, 0.1, 0.3}, want: []float32{0., 0., 0., 0.15, 0.15, 0.3}},
+		{
+			name:   "chain",
+			filter: Chain(NewMedian(3), NewDeadband(0.1)),
+			values: []float32{0., 0., 1., 0.05, 0.5, 0.5},
+			want:   []float32{0., 0., 0., 0., 0.5, 0.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apply(tt.filter, tt.values...); !equals(got, tt.want) {
+				t.Errorf("Apply() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilters_Reset(t *testing.T) {
+	for name, f := range map[string]Filter{
+		"ema":        NewEMA(0.5),
+		"median":     NewMedian(3),
+		"rate limit": NewRateLimit(1.),
+		"deadband":   NewDeadband(0.5),
+		"chain":      Chain(NewEMA(0.5), NewDeadband(0.5)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			apply(f, 0., 0., 0.)
+			f.Reset()
+			if got := f.Apply(1., time.Now()); got != 1. {
+				t.Errorf("Apply() after Reset() = %v, want 1", got)
+			}
+		})
+	}
+}
@@ -3,21 +3,35 @@ package metrics
 import (
 	"context"
 	stdout "go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/metric/global"
 	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
 	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/nonrecording"
 	"go.opentelemetry.io/otel/metric/unit"
 	controller "go.opentelemetry.io/otel/sdk/metric/controller/basic"
 	processor "go.opentelemetry.io/otel/sdk/metric/processor/basic"
 	"go.opentelemetry.io/otel/sdk/metric/selector/simple"
 	"go.uber.org/zap"
+	"sync/atomic"
 )
 
 var (
-	FrameAge          syncint64.Histogram
-	InferenceDuration syncint64.Histogram
+	FrameAge                syncint64.Histogram
+	InferenceDuration       syncint64.Histogram
+	DeviceInferenceDuration syncint64.Histogram
+	DroppedFrames           syncint64.Counter
+	ShadowDisagreement      syncfloat64.Histogram
+
+	driveMode int64
 )
 
+// SetDriveMode update current drive mode exposed by robocar.drive_mode gauge
+func SetDriveMode(mode int64) {
+	atomic.StoreInt64(&driveMode, mode)
+}
+
 func initMeter(ctx context.Context) func() {
 	zap.S().Info("init telemetry")
 	exporter, err := stdout.New(
@@ -52,11 +66,20 @@ func initMeter(ctx context.Context) func() {
 
 }
 
+func init() {
+	// Instruments are usable before Init, they record nothing until then
+	initInstruments(nonrecording.NewNoopMeter())
+}
+
 func Init(ctx context.Context) func() {
 	cleaner := initMeter(ctx)
+	initInstruments(global.Meter("robocar/rc-steering"))
+	return cleaner
+}
+
+func initInstruments(meter metric.Meter) {
 	var err error
 
-	meter := global.Meter("robocar/rc-steering")
 	FrameAge, err = meter.SyncInt64().Histogram(
 		"robocar.frame_age",
 		instrument.WithUnit(unit.Milliseconds),
@@ -73,6 +96,41 @@ func Init(ctx context.Context) func() {
 	if err != nil {
 		zap.S().Panicf("unable to instantiate InferenceDuration histogram: %v", err)
 	}
-
-	return cleaner
+	DeviceInferenceDuration, err = meter.SyncInt64().Histogram(
+		"robocar.device_inference_duration",
+		instrument.WithUnit(unit.Milliseconds),
+		instrument.WithDescription("inference duration by device of engine pool"),
+	)
+	if err != nil {
+		zap.S().Panicf("unable to instantiate DeviceInferenceDuration histogram: %v", err)
+	}
+	DroppedFrames, err = meter.SyncInt64().Counter(
+		"robocar.dropped_frames",
+		instrument.WithUnit(unit.Dimensionless),
+		instrument.WithDescription("frames dropped without inference"),
+	)
+	if err != nil {
+		zap.S().Panicf("unable to instantiate DroppedFrames counter: %v", err)
+	}
+	ShadowDisagreement, err = meter.SyncFloat64().Histogram(
+		"robocar.shadow_disagreement",
+		instrument.WithUnit(unit.Dimensionless),
+		instrument.WithDescription("absolute difference between primary and shadow models predictions"),
+	)
+	if err != nil {
+		zap.S().Panicf("unable to instantiate ShadowDisagreement histogram: %v", err)
+	}
+	driveModeGauge, err := meter.AsyncInt64().Gauge(
+		"robocar.drive_mode",
+		instrument.WithDescription("current drive mode, 0: invalid, 1: user, 2: pilot"),
+	)
+	if err != nil {
+		zap.S().Panicf("unable to instantiate DriveMode gauge: %v", err)
+	}
+	err = meter.RegisterCallback([]instrument.Asynchronous{driveModeGauge}, func(ctx context.Context) {
+		driveModeGauge.Observe(ctx, atomic.LoadInt64(&driveMode))
+	})
+	if err != nil {
+		zap.S().Panicf("unable to register DriveMode gauge callback: %v", err)
+	}
 }
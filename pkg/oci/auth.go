@@ -0,0 +1,229 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/retry"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// RegistryOptions configure access to oci registries
+type RegistryOptions struct {
+	// PlainHTTP use http instead of https
+	PlainHTTP bool
+
+	// Username and Password are used for basic authentication
+	Username string
+	Password string
+	// Token is a bearer token sent to registry
+	Token string
+	// DockerConfig is a docker config.json where to look for credentials when none is set, credentials stores and
+	// helpers are supported
+	DockerConfig string
+
+	// CAFile is a PEM bundle of certificate authorities trusted in addition to system ones
+	CAFile string
+	// InsecureSkipVerify disable verification of registry certificate
+	InsecureSkipVerify bool
+
+	// RequireOnline fail pulls when registry is unreachable instead of using cached models
+	RequireOnline bool
+}
+
+// DefaultDockerConfig return path of docker config.json, from DOCKER_CONFIG env or in user home directory
+func DefaultDockerConfig() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// configure set http client and credentials of registry reg
+func (o RegistryOptions) configure(reg *remote.Registry) error {
+	reg.RepositoryOptions.PlainHTTP = o.PlainHTTP
+
+	httpClient, err := o.httpClient()
+	if err != nil {
+		return err
+	}
+	client := &auth.Client{
+		Client: httpClient,
+		Header: http.Header{"User-Agent": {"robocar-steering"}},
+		Cache:  auth.NewCache(),
+	}
+
+	host := reg.Reference.Host()
+	switch {
+	case o.Token != "":
+		client.Credential = auth.StaticCredential(reg.Reference.Registry, auth.Credential{AccessToken: o.Token})
+	case o.Username != "":
+		client.Credential = auth.StaticCredential(reg.Reference.Registry, auth.Credential{Username: o.Username, Password: o.Password})
+	case o.DockerConfig != "":
+		client.Credential = func(_ context.Context, target string) (auth.Credential, error) {
+			return dockerCredential(o.DockerConfig, target)
+		}
+	}
+	zap.S().Debugf("registry %v, plain http: %v, credentials: %v", host, o.PlainHTTP, client.Credential != nil)
+	reg.RepositoryOptions.Client = client
+	return nil
+}
+
+func (o RegistryOptions) httpClient() (*http.Client, error) {
+	if o.CAFile == "" && !o.InsecureSkipVerify {
+		return retry.DefaultClient, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA bundle %v", o.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if o.InsecureSkipVerify {
+		zap.S().Warn("registry certificate verification is disabled")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: retry.NewTransport(transport)}, nil
+}
+
+// dockerConfig is the subset of docker config.json related to credentials
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredsStore  string                `json:"credsStore"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+}
+
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+	RegistryToken string `json:"registrytoken"`
+}
+
+// dockerCredential return credential of registry host from docker config file configPath. Missing config file means
+// no credential
+func dockerCredential(configPath, host string) (auth.Credential, error) {
+	content, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return auth.EmptyCredential, nil
+	}
+	if err != nil {
+		return auth.EmptyCredential, fmt.Errorf("unable to read docker config: %w", err)
+	}
+	var cfg dockerConfig
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return auth.EmptyCredential, fmt.Errorf("unable to parse docker config %v: %w", configPath, err)
+	}
+
+	hosts := []string{host}
+	if host == "registry-1.docker.io" {
+		hosts = append(hosts, "index.docker.io", "docker.io")
+	}
+	for _, h := range hosts {
+		if helper, ok := cfg.CredHelpers[h]; ok {
+			return helperCredential(helper, h)
+		}
+	}
+	for key, a := range cfg.Auths {
+		for _, h := range hosts {
+			if normalizeHost(key) == h {
+				return a.credential()
+			}
+		}
+	}
+	if cfg.CredsStore != "" {
+		return helperCredential(cfg.CredsStore, host)
+	}
+	return auth.EmptyCredential, nil
+}
+
+// normalizeHost remove scheme and path of docker config auths keys, as 'https://index.docker.io/v1/'
+func normalizeHost(key string) string {
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	host, _, _ := strings.Cut(key, "/")
+	return host
+}
+
+func (a dockerAuth) credential() (auth.Credential, error) {
+	cred := auth.Credential{
+		Username:     a.Username,
+		Password:     a.Password,
+		RefreshToken: a.IdentityToken,
+		AccessToken:  a.RegistryToken,
+	}
+	if a.Auth != "" {
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return auth.EmptyCredential, fmt.Errorf("bad auth value in docker config: %w", err)
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return auth.EmptyCredential, fmt.Errorf("bad auth value in docker config, expected 'username:password'")
+		}
+		cred.Username, cred.Password = user, password
+	}
+	return cred, nil
+}
+
+// runHelper run docker credential helper with input on stdin, replaced by tests
+var runHelper = func(helper string, input string) ([]byte, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// helperCredential query docker credential helper for host credential
+func helperCredential(helper, host string) (auth.Credential, error) {
+	out, err := runHelper(helper, host)
+	if err != nil {
+		if strings.Contains(err.Error(), "credentials not found") {
+			return auth.EmptyCredential, nil
+		}
+		return auth.EmptyCredential, fmt.Errorf("unable to get credentials from docker-credential-%v: %w", helper, err)
+	}
+	var result struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return auth.EmptyCredential, fmt.Errorf("bad output of docker-credential-%v: %w", helper, err)
+	}
+	// Identity tokens are returned with '<token>' username
+	if result.Username == "<token>" {
+		return auth.Credential{RefreshToken: result.Secret}, nil
+	}
+	return auth.Credential{Username: result.Username, Password: result.Secret}, nil
+}
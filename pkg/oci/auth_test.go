@@ -0,0 +1,142 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_dockerCredential(t *testing.T) {
+	config := `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNzd29yZA=="},
+    "registry.example.com": {"identitytoken": "identity"}
+  },
+  "credHelpers": {"helper.example.com": "fake"},
+  "credsStore": "store"
+}`
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatalf("unable to write docker config: %v", err)
+	}
+	oldRunHelper := runHelper
+	t.Cleanup(func() { runHelper = oldRunHelper })
+	runHelper = func(helper string, host string) ([]byte, error) {
+		switch helper {
+		case "fake":
+			return []byte(`{"ServerURL":"` + host + `","Username":"helper","Secret":"secret"}`), nil
+		case "store":
+			if host == "store.example.com" {
+				return []byte(`{"Username":"<token>","Secret":"refresh"}`), nil
+			}
+			return nil, fmt.Errorf("exit status 1: credentials not found in native keychain")
+		}
+		return nil, fmt.Errorf("unknown helper %v", helper)
+	}
+
+	tests := []struct {
+		name       string
+		configPath string
+		host       string
+		want       auth.Credential
+		wantErr    bool
+	}{
+		{name: "docker hub", configPath: configPath, host: "registry-1.docker.io", want: auth.Credential{Username: "user", Password: "password"}},
+		{name: "identity token", configPath: configPath, host: "registry.example.com", want: auth.Credential{RefreshToken: "identity"}},
+		{name: "credential helper", configPath: configPath, host: "helper.example.com", want: auth.Credential{Username: "helper", Password: "secret"}},
+		{name: "credentials store", configPath: configPath, host: "store.example.com", want: auth.Credential{RefreshToken: "refresh"}},
+		{name: "not found in store", configPath: configPath, host: "unknown.example.com", want: auth.EmptyCredential},
+		{name: "missing config", configPath: filepath.Join(t.TempDir(), "config.json"), host: "registry.example.com", want: auth.EmptyCredential},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dockerCredential(tt.configPath, tt.host)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("dockerCredential() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("dockerCredential() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestRegistry serve a manifest without layer, by tag 'v1' or by digest, of any repository to clients authenticated
+// with user:password
+func newTestRegistry(t *testing.T, tls bool) *httptest.Server {
+	const config = "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+	const manifest = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[]}`
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != "user" || password != "password" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		content, contentType := manifest, "application/vnd.oci.image.manifest.v1+json"
+		digest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(manifest)))
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifests/v1"), strings.HasSuffix(r.URL.Path, "/manifests/"+digest):
+		case strings.HasSuffix(r.URL.Path, "/blobs/"+config):
+			content, contentType = "{}", "application/octet-stream"
+			digest = config
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Header().Set("Docker-Content-Digest", digest)
+		if r.Method == http.MethodGet {
+			_, _ = w.Write([]byte(content))
+		}
+	})
+	var server *httptest.Server
+	if tls {
+		server = httptest.NewTLSServer(handler)
+	} else {
+		server = httptest.NewServer(handler)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRegistryOptions(t *testing.T) {
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	tlsServer := newTestRegistry(t, true)
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsServer.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatalf("unable to write CA file: %v", err)
+	}
+	plainServer := newTestRegistry(t, false)
+
+	tests := []struct {
+		name    string
+		server  *httptest.Server
+		opts    RegistryOptions
+		wantErr bool
+	}{
+		{name: "tls with CA bundle", server: tlsServer, opts: RegistryOptions{Username: "user", Password: "password", CAFile: caFile}},
+		{name: "tls insecure", server: tlsServer, opts: RegistryOptions{Username: "user", Password: "password", InsecureSkipVerify: true}},
+		{name: "tls unknown authority", server: tlsServer, opts: RegistryOptions{Username: "user", Password: "password"}, wantErr: true},
+		{name: "bad credentials", server: tlsServer, opts: RegistryOptions{Username: "user", Password: "bad", CAFile: caFile}, wantErr: true},
+		{name: "plain http", server: plainServer, opts: RegistryOptions{Username: "user", Password: "password", PlainHTTP: true}},
+		{name: "https on plain http registry", server: plainServer, opts: RegistryOptions{Username: "user", Password: "password"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := strings.TrimPrefix(strings.TrimPrefix(tt.server.URL, "https://"), "http://")
+			_, _, err := pull(context.Background(), cache{dir: t.TempDir()}, host, "robocar/model", "v1", tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("pull() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/file"
+	"oras.land/oras-go/v2/registry"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	cacheIndexFile    = "index.json"
+	cacheManifestFile = "manifest.json"
+)
+
+// cache store pulled models under dir, addressed by manifest digest: 'blobs/<algorithm>/<digest>' directories
+// contain files of a manifest and the manifest itself. Index file map references to digest of their last pull
+type cache struct {
+	dir string
+}
+
+func (c cache) path(d digest.Digest) string {
+	return filepath.Join(c.dir, "blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// layerFile return path in dir of file of layer, from its title annotation. Titles come from registry, only plain
+// file names are accepted so that files outside dir can't be referenced
+func layerFile(dir string, layer v1.Descriptor) (string, error) {
+	title := layer.Annotations[v1.AnnotationTitle]
+	if title == "" || title == "." || title == ".." || strings.ContainsAny(title, `/\`) || filepath.IsAbs(title) || filepath.VolumeName(title) != "" {
+		return "", fmt.Errorf("invalid layer title '%v'", title)
+	}
+	return filepath.Join(dir, title), nil
+}
+
+// manifest return cached manifest d, once checked against d. Error wraps os.ErrNotExist if manifest isn't in cache
+func (c cache) manifest(d digest.Digest) (*v1.Manifest, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid digest '%v': %w", d, err)
+	}
+	b, err := os.ReadFile(filepath.Join(c.path(d), cacheManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	if actual := d.Algorithm().FromBytes(b); actual != d {
+		return nil, fmt.Errorf("cached manifest %v doesn't match its digest, got %v", d, actual)
+	}
+	var manifest v1.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal cached manifest %v: %w", d, err)
+	}
+	return &manifest, nil
+}
+
+// verify check that model file of cached manifest d, its first layer, matches size and digest of layer descriptor
+func (c cache) verify(d digest.Digest, manifest *v1.Manifest) error {
+	if len(manifest.Layers) == 0 {
+		return fmt.Errorf("manifest %v has no layer", d)
+	}
+	layer := manifest.Layers[0]
+	path, err := layerFile(c.path(d), layer)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open model file: %w", err)
+	}
+	defer f.Close()
+
+	if err := layer.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid layer digest '%v': %w", layer.Digest, err)
+	}
+	verifier := layer.Digest.Verifier()
+	n, err := io.Copy(verifier, f)
+	if err != nil {
+		return fmt.Errorf("unable to read model file: %w", err)
+	}
+	if n != layer.Size {
+		return fmt.Errorf("model file size %v doesn't match layer size %v", n, layer.Size)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("model file doesn't match layer digest %v", layer.Digest)
+	}
+	return nil
+}
+
+// store pull manifest desc and its files from repo. Files are written in a temporary directory, then moved to cache
+// once complete
+func (c cache) store(ctx context.Context, repo registry.Repository, desc v1.Descriptor) (*v1.Manifest, error) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create models cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(c.dir, ".pull-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create pull directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	fs, err := file.New(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create file store: %w", err)
+	}
+	_, err = oras.Copy(ctx, repo, desc.Digest.String(), fs, "", oras.DefaultCopyOptions)
+	fs.Close()
+	if err != nil {
+		return nil, fmt.Errorf("unable to pull %v: %w", desc.Digest, err)
+	}
+
+	b, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch manifest %v: %w", desc.Digest, err)
+	}
+	var manifest v1.Manifest
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal manifest %v: %w", desc.Digest, err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, cacheManifestFile), b, 0644); err != nil {
+		return nil, fmt.Errorf("unable to write manifest: %w", err)
+	}
+
+	dst := c.path(desc.Digest)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, fmt.Errorf("unable to create models cache: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return nil, fmt.Errorf("unable to move pulled model to cache: %w", err)
+	}
+	return &manifest, nil
+}
+
+// index return references with digest of their last pull
+func (c cache) index() (map[string]digest.Digest, error) {
+	index := make(map[string]digest.Digest)
+	b, err := os.ReadFile(filepath.Join(c.dir, cacheIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cache index: %w", err)
+	}
+	if err := json.Unmarshal(b, &index); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal cache index: %w", err)
+	}
+	return index, nil
+}
+
+// resolve return digest of last pull of ref
+func (c cache) resolve(ref string) (digest.Digest, error) {
+	index, err := c.index()
+	if err != nil {
+		return "", err
+	}
+	d, ok := index[ref]
+	if !ok {
+		return "", fmt.Errorf("no cached model for '%v'", ref)
+	}
+	return d, nil
+}
+
+// tag record d as last pull of ref
+func (c cache) tag(ref string, d digest.Digest) error {
+	index, err := c.index()
+	if err != nil {
+		return err
+	}
+	if index[ref] == d {
+		return nil
+	}
+	index[ref] = d
+	b, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal cache index: %w", err)
+	}
+	// Replace index atomically
+	tmp := filepath.Join(c.dir, cacheIndexFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("unable to write cache index: %w", err)
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, cacheIndexFile))
+}
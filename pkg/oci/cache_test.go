@@ -0,0 +1,188 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newModelRegistry serve, by tag 'v1' or by digest, a model manifest with a single 'model.tflite' layer of any
+// repository
+func newModelRegistry(t *testing.T) *httptest.Server {
+	blobs := make(map[string][]byte)
+	add := func(b []byte) v1.Descriptor {
+		d := fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+		blobs[d] = b
+		return v1.Descriptor{Digest: digest.Digest(d), Size: int64(len(b))}
+	}
+	config := add([]byte("{}"))
+	config.MediaType = "application/vnd.oci.empty.v1+json"
+	layer := add([]byte("tflite model"))
+	layer.MediaType = "application/vnd.robocar.model.tflite"
+	layer.Annotations = map[string]string{v1.AnnotationTitle: "model.tflite"}
+	manifest, err := json.Marshal(v1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []v1.Descriptor{layer},
+		Annotations: map[string]string{
+			"type":       "categorical",
+			"img_width":  "160",
+			"img_height": "120",
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal manifest: %v", err)
+	}
+	manifestDigest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifest))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var content []byte
+		contentType := "application/octet-stream"
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/manifests/v1"), strings.HasSuffix(r.URL.Path, "/manifests/"+manifestDigest):
+			content, contentType = manifest, v1.MediaTypeImageManifest
+		case strings.Contains(r.URL.Path, "/blobs/"):
+			content = blobs[r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]]
+		}
+		if content == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(content)))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(content)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPullOciImage_cache(t *testing.T) {
+	server := newModelRegistry(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+	modelsDir := t.TempDir()
+	opts := RegistryOptions{PlainHTTP: true}
+
+	model, err := PullOciImage(context.Background(), host, "robocar/model", "v1", modelsDir, opts)
+	if err != nil {
+		t.Fatalf("PullOciImage() error = %v", err)
+	}
+	content, err := os.ReadFile(model.Path)
+	if err != nil {
+		t.Fatalf("unable to read pulled model: %v", err)
+	}
+	if string(content) != "tflite model" {
+		t.Errorf("pulled model content = %q, want %q", content, "tflite model")
+	}
+	if model.ImgWidth != 160 || model.ImgHeight != 120 {
+		t.Errorf("pulled model size = %vx%v, want 160x120", model.ImgWidth, model.ImgHeight)
+	}
+	if !strings.HasPrefix(model.Path, filepath.Join(modelsDir, "blobs", "sha256")) {
+		t.Errorf("model path %v isn't digest addressed", model.Path)
+	}
+
+	// Registry is unreachable
+	server.Close()
+
+	cached, err := PullOciImage(context.Background(), host, "robocar/model", "v1", modelsDir, opts)
+	if err != nil {
+		t.Fatalf("PullOciImage() from cache error = %v", err)
+	}
+	if cached.Path != model.Path {
+		t.Errorf("cached model path = %v, want %v", cached.Path, model.Path)
+	}
+
+	if _, err := PullOciImage(context.Background(), host, "robocar/model", "v2", modelsDir, opts); err == nil {
+		t.Errorf("PullOciImage() of tag not in cache, want error")
+	}
+	pinned, err := PullOciImage(context.Background(), host, "robocar/model@"+model.Digest.String(), "", modelsDir, RegistryOptions{PlainHTTP: true, RequireOnline: true})
+	if err != nil {
+		t.Fatalf("PullOciImage() of cached digest error = %v", err)
+	}
+	if pinned.Path != model.Path {
+		t.Errorf("pinned model path = %v, want %v", pinned.Path, model.Path)
+	}
+
+	opts.RequireOnline = true
+	if _, err := PullOciImage(context.Background(), host, "robocar/model", "v1", modelsDir, opts); err == nil {
+		t.Errorf("PullOciImage() with RequireOnline and unreachable registry, want error")
+	}
+
+	// Model file is corrupted
+	opts.RequireOnline = false
+	if err := os.WriteFile(model.Path, []byte("tflite modeL"), 0644); err != nil {
+		t.Fatalf("unable to corrupt model: %v", err)
+	}
+	if _, err := PullOciImage(context.Background(), host, "robocar/model", "v1", modelsDir, opts); err == nil {
+		t.Errorf("PullOciImage() of corrupted model, want error")
+	}
+}
+
+func Test_cache_manifest(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2,"layers":[]}`)
+	d := digest.FromBytes(manifest)
+	tests := []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{name: "valid", content: manifest},
+		{name: "tampered", content: []byte(`{"schemaVersion":2,"layers":[{}]}`), wantErr: true},
+		{name: "not json", content: []byte("not json"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cache{dir: t.TempDir()}
+			if err := os.MkdirAll(c.path(d), 0755); err != nil {
+				t.Fatalf("unable to create cache: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(c.path(d), cacheManifestFile), tt.content, 0644); err != nil {
+				t.Fatalf("unable to write manifest: %v", err)
+			}
+			_, err := c.manifest(d)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("manifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_layerFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		title   string
+		want    string
+		wantErr bool
+	}{
+		{name: "file name", title: "model.tflite", want: filepath.Join("cache", "model.tflite")},
+		{name: "missing", title: "", wantErr: true},
+		{name: "parent", title: "..", wantErr: true},
+		{name: "traversal", title: "../../etc/x", wantErr: true},
+		{name: "absolute", title: "/etc/x", wantErr: true},
+		{name: "sub directory", title: "models/model.tflite", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := layerFile("cache", v1.Descriptor{Annotations: map[string]string{v1.AnnotationTitle: tt.title}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("layerFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("layerFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
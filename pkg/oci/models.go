@@ -2,79 +2,197 @@ package oci
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"github.com/opencontainers/go-digest"
 	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 	"go.uber.org/zap"
-	"oras.land/oras-go/v2"
-	"oras.land/oras-go/v2/content"
-	"oras.land/oras-go/v2/content/file"
 	"oras.land/oras-go/v2/registry"
 	"oras.land/oras-go/v2/registry/remote"
-	"path"
+	"os"
 	"strconv"
+	"strings"
 )
 
-func PullOciImage(ctx context.Context, regName, repoName, tag, modelsDir string) (modelPath string, modelType tools.ModelType, imgWidth, imgHeight int, horizon int, err error) {
+// Model describes a model pulled from an oci registry, configured from manifest annotations
+type Model struct {
+	Path      string
+	Type      tools.ModelType
+	ImgWidth  int
+	ImgHeight int
+	Horizon   int
+	// SteeringOutput and ThrottleOutput are optional output tensors to use, by index or name
+	SteeringOutput string
+	ThrottleOutput string
+	// Annotations of manifest, for settings not known by this package
+	Annotations map[string]string
+	// Digest of manifest, identify exactly pulled model
+	Digest digest.Digest
+}
 
-	repo, err := getRepository(ctx, regName, repoName)
+// PullOciImage pull model referenced by reference, a tag or a digest, from repository repoName of registry regName
+// into modelsDir cache. repoName may also be pinned to a digest with 'repository@sha256:...' form. Models are only
+// downloaded when their manifest isn't already in cache, and model file is checked against its manifest digest before
+// use. When registry is unreachable, last model pulled for this reference is used unless opts.RequireOnline is set
+func PullOciImage(ctx context.Context, regName, repoName, reference, modelsDir string, opts RegistryOptions) (Model, error) {
+	c := cache{dir: modelsDir}
+	repoName, reference, pinned, err := splitReference(repoName, reference)
 	if err != nil {
-		err = fmt.Errorf("unable to fetch oci artifact from '%s/%s: %w", regName, repoName, err)
-		return
+		return Model{}, err
+	}
+	ref := fmt.Sprintf("%s/%s:%s", regName, repoName, reference)
+	if pinned != "" {
+		ref = fmt.Sprintf("%s/%s@%s", regName, repoName, reference)
 	}
 
-	manifest, err := fetchManifest(ctx, repo, tag)
-	if err != nil {
-		err = fmt.Errorf("unable to fetch manifest '%s/%s:%s': %w", regName, repoName, tag, err)
-		return
+	var manifest *v1.Manifest
+	var d digest.Digest
+	if pinned != "" {
+		// Content of a digest never changes, no need to reach registry when it is already in cache
+		if manifest, err = c.manifest(pinned); err == nil && c.verify(pinned, manifest) == nil {
+			d = pinned
+		}
+	}
+	if d == "" {
+		manifest, d, err = pull(ctx, c, regName, repoName, reference, opts)
+	}
+	switch {
+	case err == nil && pinned != "" && d != pinned:
+		return Model{}, fmt.Errorf("registry resolved '%v' to unexpected digest %v", ref, d)
+	case err == nil && pinned == "":
+		if err := c.tag(ref, d); err != nil {
+			zap.S().Warnf("unable to update models cache index: %v", err)
+		}
+	case err != nil:
+		if opts.RequireOnline {
+			return Model{}, err
+		}
+		d = pinned
+		if d == "" {
+			var cacheErr error
+			d, cacheErr = c.resolve(ref)
+			if cacheErr != nil {
+				return Model{}, fmt.Errorf("%w, and no cached model: %v", err, cacheErr)
+			}
+		}
+		var cacheErr error
+		manifest, cacheErr = c.manifest(d)
+		if cacheErr != nil {
+			return Model{}, fmt.Errorf("%w, and unable to read cached model: %v", err, cacheErr)
+		}
+		zap.S().Warnw("unable to pull model, use last model pulled",
+			"reference", ref,
+			"digest", d,
+			"error", err,
+		)
 	}
 	zap.S().Infof("Manifest: %v", manifest)
 
-	// 0. Create a file store
-	modelStore := path.Join(modelsDir, manifest.Annotations["category"])
-	fs, err := file.New(modelStore)
+	if err := c.verify(d, manifest); err != nil {
+		return Model{}, fmt.Errorf("model '%v' is corrupted: %w", ref, err)
+	}
+	zap.S().Infow("model resolved",
+		"reference", ref,
+		"digest", d,
+	)
+	model, err := modelFromManifest(manifest, c.path(d))
+	model.Digest = d
+	return model, err
+}
+
+// splitReference return repository and reference of model, and digest it is pinned to if any. Digest may be the
+// reference itself or suffix of repoName as 'repository@sha256:...'
+func splitReference(repoName, reference string) (string, string, digest.Digest, error) {
+	if repo, d, ok := strings.Cut(repoName, "@"); ok {
+		if reference != "" && reference != d {
+			return "", "", "", fmt.Errorf("repository '%v' is pinned to a digest, unexpected reference '%v'", repoName, reference)
+		}
+		repoName, reference = repo, d
+	}
+	if !strings.Contains(reference, ":") {
+		return repoName, reference, "", nil
+	}
+	d, err := digest.Parse(reference)
 	if err != nil {
-		return
+		return "", "", "", fmt.Errorf("bad digest '%v': %w", reference, err)
+	}
+	return repoName, reference, d, nil
+}
+
+// pull manifest of reference from registry, with its files if not in cache
+func pull(ctx context.Context, c cache, regName, repoName, reference string, opts RegistryOptions) (*v1.Manifest, digest.Digest, error) {
+	repo, err := getRepository(ctx, regName, repoName, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to fetch oci artifact from '%s/%s: %w", regName, repoName, err)
+	}
+	desc, err := repo.Resolve(ctx, reference)
+	if err != nil {
+		return nil, "", fmt.Errorf("unable to resolve '%s/%s:%s': %w", regName, repoName, reference, err)
 	}
-	defer fs.Close()
+	zap.S().Debugf("model descriptor: %#v", desc)
 
-	// 2. Copy from the remote repoName to the file store
-	_, err = oras.Copy(ctx, repo, tag, fs, tag, oras.DefaultCopyOptions)
+	manifest, err := c.manifest(desc.Digest)
+	if err == nil {
+		err = c.verify(desc.Digest, manifest)
+	}
+	if err == nil {
+		zap.S().Infof("model %v already in cache", desc.Digest)
+		return manifest, desc.Digest, nil
+	}
+	if manifest != nil || !errors.Is(err, os.ErrNotExist) {
+		zap.S().Warnf("invalid cached model, pull it again: %v", err)
+		if err := os.RemoveAll(c.path(desc.Digest)); err != nil {
+			return nil, "", fmt.Errorf("unable to remove invalid cached model: %w", err)
+		}
+	}
+	manifest, err = c.store(ctx, repo, desc)
 	if err != nil {
+		return nil, "", fmt.Errorf("unable to pull '%s/%s:%s': %w", regName, repoName, reference, err)
+	}
+	return manifest, desc.Digest, nil
+}
+
+// modelFromManifest read model configuration from manifest annotations, model file is the first layer in dir
+func modelFromManifest(manifest *v1.Manifest, dir string) (model Model, err error) {
+	if len(manifest.Layers) == 0 {
+		err = fmt.Errorf("manifest has no layer")
 		return
 	}
-	modelType = tools.ParseModelType(manifest.Annotations["type"])
-	imgWidth, err = strconv.Atoi(manifest.Annotations["img_width"])
+	model.Type = tools.ParseModelType(manifest.Annotations["type"])
+	model.ImgWidth, err = strconv.Atoi(manifest.Annotations["img_width"])
 	if err != nil {
 		err = fmt.Errorf("unable to convert image width '%v' to integer: %w", manifest.Annotations["img_width"], err)
 		return
 	}
-	imgHeight, err = strconv.Atoi(manifest.Annotations["img_height"])
+	model.ImgHeight, err = strconv.Atoi(manifest.Annotations["img_height"])
 	if err != nil {
 		err = fmt.Errorf("unable to convert image height '%v' to integer: %w", manifest.Annotations["img_height"], err)
 		return
 	}
 	if _, ok := manifest.Annotations["horizon"]; ok {
-		horizon, err = strconv.Atoi(manifest.Annotations["horizon"])
+		model.Horizon, err = strconv.Atoi(manifest.Annotations["horizon"])
 		if err != nil {
 			err = fmt.Errorf("unable to convert horizon '%v' to integer: %v", manifest.Annotations["horizon"], err)
 			return
 		}
-	} else {
-		horizon = 0
 	}
-	modelPath = path.Join(modelStore, manifest.Layers[0].Annotations["org.opencontainers.image.title"])
+	model.SteeringOutput = manifest.Annotations["steering_output"]
+	model.ThrottleOutput = manifest.Annotations["throttle_output"]
+	model.Annotations = manifest.Annotations
+	model.Path, err = layerFile(dir, manifest.Layers[0])
 	return
 }
 
-func getRepository(ctx context.Context, registryName string, repoName string) (registry.Repository, error) {
+func getRepository(ctx context.Context, registryName string, repoName string, opts RegistryOptions) (registry.Repository, error) {
 
 	reg, err := remote.NewRegistry(registryName)
 	if err != nil {
 		return nil, fmt.Errorf("bad registry '%v': %w", registryName, err)
 	}
-	reg.RepositoryOptions.PlainHTTP = true
+	if err := opts.configure(reg); err != nil {
+		return nil, fmt.Errorf("unable to configure registry '%v': %w", registryName, err)
+	}
 
 	// For debug
 	//reg.Repositories(ctx, "", func(repos []string) error {
@@ -100,28 +218,3 @@ func getRepository(ctx context.Context, registryName string, repoName string) (r
 	*/
 	return repo, nil
 }
-
-func fetchManifest(ctx context.Context, repo registry.Repository, tag string) (*v1.Manifest, error) {
-
-	descriptor, err := repo.Resolve(ctx, tag)
-	zap.S().Debugf("model descriptor: %#v", descriptor)
-	if err != nil {
-		return nil, fmt.Errorf("unexpected error on tag resolving: %w", err)
-	}
-	rc, err := repo.Fetch(ctx, descriptor)
-	if err != nil {
-		return nil, fmt.Errorf("unable to fetch manifest for image '%s:%s': %w", repo, tag, err)
-	}
-	defer rc.Close() // don't forget to close
-	pulledBlob, err := content.ReadAll(rc, descriptor)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read manifest content for image '%s:%s': %w", repo, tag, err)
-	}
-
-	var manifest v1.Manifest
-	err = json.Unmarshal(pulledBlob, &manifest)
-	if err != nil {
-		return nil, fmt.Errorf("unable to unmarsh json manifest content for image '%s:%s': %w", repo, tag, err)
-	}
-	return &manifest, nil
-}
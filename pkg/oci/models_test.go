@@ -0,0 +1,37 @@
+package oci
+
+import (
+	"github.com/opencontainers/go-digest"
+	"testing"
+)
+
+func Test_splitReference(t *testing.T) {
+	const d = "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+	tests := []struct {
+		name          string
+		repoName      string
+		reference     string
+		wantRepo      string
+		wantReference string
+		wantPinned    digest.Digest
+		wantErr       bool
+	}{
+		{name: "tag", repoName: "robocar/model", reference: "v1", wantRepo: "robocar/model", wantReference: "v1"},
+		{name: "digest reference", repoName: "robocar/model", reference: d, wantRepo: "robocar/model", wantReference: d, wantPinned: d},
+		{name: "pinned repository", repoName: "robocar/model@" + d, wantRepo: "robocar/model", wantReference: d, wantPinned: d},
+		{name: "pinned repository with same digest", repoName: "robocar/model@" + d, reference: d, wantRepo: "robocar/model", wantReference: d, wantPinned: d},
+		{name: "pinned repository with tag", repoName: "robocar/model@" + d, reference: "v1", wantErr: true},
+		{name: "bad digest", repoName: "robocar/model@sha256:1234", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, reference, pinned, err := splitReference(tt.repoName, tt.reference)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitReference() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if repo != tt.wantRepo || reference != tt.wantReference || pinned != tt.wantPinned {
+				t.Errorf("splitReference() = %v, %v, %v, want %v, %v, %v", repo, reference, pinned, tt.wantRepo, tt.wantReference, tt.wantPinned)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"go.uber.org/zap"
+	"oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content/file"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	// ModelConfigMediaType is the config media type of model manifests
+	ModelConfigMediaType = "application/vnd.robocar.model.config.v1+json"
+	// ModelLayerMediaType is the media type of tflite model files
+	ModelLayerMediaType = "application/vnd.robocar.model.tflite"
+)
+
+// PushOciImage push model file at model.Path to tag of repository repoName in registry regName. Model configuration is
+// stored in manifest annotations, as expected by PullOciImage. Return digest of pushed manifest
+func PushOciImage(ctx context.Context, regName, repoName, tag string, model Model, opts RegistryOptions) (digest.Digest, error) {
+	annotations, err := model.annotations()
+	if err != nil {
+		return "", err
+	}
+
+	path, err := filepath.Abs(model.Path)
+	if err != nil {
+		return "", fmt.Errorf("bad model path '%v': %w", model.Path, err)
+	}
+	fs, err := file.New(filepath.Dir(path))
+	if err != nil {
+		return "", fmt.Errorf("unable to create file store: %w", err)
+	}
+	defer fs.Close()
+
+	layer, err := fs.Add(ctx, filepath.Base(path), ModelLayerMediaType, path)
+	if err != nil {
+		return "", fmt.Errorf("unable to add model file: %w", err)
+	}
+	desc, err := oras.Pack(ctx, fs, ModelConfigMediaType, []v1.Descriptor{layer}, oras.PackOptions{
+		ManifestAnnotations: annotations,
+		PackImageManifest:   true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to pack model: %w", err)
+	}
+	if err := fs.Tag(ctx, desc, tag); err != nil {
+		return "", fmt.Errorf("unable to tag model: %w", err)
+	}
+
+	repo, err := getRepository(ctx, regName, repoName, opts)
+	if err != nil {
+		return "", fmt.Errorf("unable to push oci artifact to '%s/%s: %w", regName, repoName, err)
+	}
+	if _, err := oras.Copy(ctx, fs, tag, repo, tag, oras.DefaultCopyOptions); err != nil {
+		return "", fmt.Errorf("unable to push '%s/%s:%s': %w", regName, repoName, tag, err)
+	}
+	zap.S().Infow("model pushed",
+		"reference", fmt.Sprintf("%s/%s:%s", regName, repoName, tag),
+		"digest", desc.Digest,
+	)
+	return desc.Digest, nil
+}
+
+// annotations return manifest annotations describing model configuration, extra Annotations included
+func (m Model) annotations() (map[string]string, error) {
+	if m.Type == tools.ModelTypeUnknown || m.ImgWidth <= 0 || m.ImgHeight <= 0 {
+		return nil, fmt.Errorf("model type, image width and height are mandatory")
+	}
+	annotations := make(map[string]string, len(m.Annotations)+6)
+	for k, v := range m.Annotations {
+		annotations[k] = v
+	}
+	annotations["type"] = m.Type.String()
+	annotations["img_width"] = strconv.Itoa(m.ImgWidth)
+	annotations["img_height"] = strconv.Itoa(m.ImgHeight)
+	annotations["horizon"] = strconv.Itoa(m.Horizon)
+	if m.SteeringOutput != "" {
+		annotations["steering_output"] = m.SteeringOutput
+	}
+	if m.ThrottleOutput != "" {
+		annotations["throttle_output"] = m.ThrottleOutput
+	}
+	return annotations, nil
+}
@@ -0,0 +1,147 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newMemoryRegistry serve an in-memory registry that supports blobs and manifests push and pull
+func newMemoryRegistry(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	blobs := make(map[string][]byte)
+	manifests := make(map[string][]byte)
+	uploads := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		repo, kind, ref := r.URL.Path, "", ""
+		for _, k := range []string{"/blobs/uploads/", "/blobs/", "/manifests/"} {
+			if i := strings.LastIndex(r.URL.Path, k); i >= 0 {
+				repo, kind, ref = r.URL.Path[:i], k, r.URL.Path[i+len(k):]
+				break
+			}
+		}
+		body, _ := io.ReadAll(r.Body)
+		serve := func(content []byte, contentType string) {
+			if content == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(content)))
+			if r.Method == http.MethodGet {
+				_, _ = w.Write(content)
+			}
+		}
+
+		switch {
+		case kind == "/blobs/uploads/" && r.Method == http.MethodPost:
+			uploads++
+			w.Header().Set("Location", fmt.Sprintf("%s/blobs/uploads/%d", repo, uploads))
+			w.WriteHeader(http.StatusAccepted)
+		case kind == "/blobs/uploads/" && r.Method == http.MethodPut:
+			d := r.URL.Query().Get("digest")
+			if d != fmt.Sprintf("sha256:%x", sha256.Sum256(body)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			blobs[d] = body
+			w.Header().Set("Docker-Content-Digest", d)
+			w.WriteHeader(http.StatusCreated)
+		case kind == "/blobs/":
+			serve(blobs[ref], "application/octet-stream")
+		case kind == "/manifests/" && r.Method == http.MethodPut:
+			d := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+			manifests[repo+"@"+d] = body
+			manifests[repo+":"+ref] = body
+			w.Header().Set("Docker-Content-Digest", d)
+			w.WriteHeader(http.StatusCreated)
+		case kind == "/manifests/":
+			sep := ":"
+			if strings.HasPrefix(ref, "sha256:") {
+				sep = "@"
+			}
+			serve(manifests[repo+sep+ref], "application/vnd.oci.image.manifest.v1+json")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPushOciImage(t *testing.T) {
+	server := newMemoryRegistry(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+	opts := RegistryOptions{PlainHTTP: true}
+
+	modelPath := filepath.Join(t.TempDir(), "model_categorical_160x120h20.tflite")
+	if err := os.WriteFile(modelPath, []byte("tflite model"), 0644); err != nil {
+		t.Fatalf("unable to write model: %v", err)
+	}
+	model := Model{
+		Path:           modelPath,
+		Type:           tools.ModelTypeCategorical,
+		ImgWidth:       160,
+		ImgHeight:      120,
+		Horizon:        20,
+		SteeringOutput: "angle",
+		Annotations:    map[string]string{"category": "steering", "decoder": "expectation"},
+	}
+
+	d, err := PushOciImage(context.Background(), host, "robocar/model", "v1", model, opts)
+	if err != nil {
+		t.Fatalf("PushOciImage() error = %v", err)
+	}
+
+	pulled, err := PullOciImage(context.Background(), host, "robocar/model", "v1", t.TempDir(), opts)
+	if err != nil {
+		t.Fatalf("PullOciImage() error = %v", err)
+	}
+	if pulled.Digest != d {
+		t.Errorf("pulled digest = %v, want %v", pulled.Digest, d)
+	}
+	if filepath.Base(pulled.Path) != filepath.Base(modelPath) {
+		t.Errorf("pulled model file = %v, want %v", filepath.Base(pulled.Path), filepath.Base(modelPath))
+	}
+	content, err := os.ReadFile(pulled.Path)
+	if err != nil || string(content) != "tflite model" {
+		t.Errorf("pulled model content = %q, %v, want %q", content, err, "tflite model")
+	}
+	got := Model{
+		Type:           pulled.Type,
+		ImgWidth:       pulled.ImgWidth,
+		ImgHeight:      pulled.ImgHeight,
+		Horizon:        pulled.Horizon,
+		SteeringOutput: pulled.SteeringOutput,
+		ThrottleOutput: pulled.ThrottleOutput,
+	}
+	want := model
+	want.Path, want.Annotations = "", nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("pulled model = %+v, want %+v", got, want)
+	}
+	for k, v := range model.Annotations {
+		if pulled.Annotations[k] != v {
+			t.Errorf("pulled annotation %v = %v, want %v", k, pulled.Annotations[k], v)
+		}
+	}
+
+	if _, err := PushOciImage(context.Background(), host, "robocar/model", "v2", Model{Path: modelPath}, opts); err == nil {
+		t.Errorf("PushOciImage() without model configuration, want error")
+	}
+}
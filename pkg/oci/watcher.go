@@ -0,0 +1,143 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"github.com/opencontainers/go-digest"
+	"go.uber.org/zap"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Watcher poll a registry tag and pull models it references when they change, so that they are rolled out without
+// restart
+type Watcher struct {
+	regName, repoName, tag, modelsDir string
+	opts                              RegistryOptions
+	interval, jitter                  time.Duration
+	update                            func(Model) error
+
+	// current is the digest of running model, it may also be changed by models reloaded outside of watcher
+	muCurrent sync.Mutex
+	current   digest.Digest
+	// failed is the last digest that couldn't be pulled or updated, only accessed by Run goroutine
+	failed digest.Digest
+	paused int32
+}
+
+// NewWatcher instantiate a watcher of tag in repoName of regName, current is the digest of running model. New models
+// are pulled into modelsDir and handed to update. Registry is polled every interval, delayed by a random duration up
+// to jitter so that many cars don't poll at the same time
+func NewWatcher(regName, repoName, tag, modelsDir string, opts RegistryOptions, current digest.Digest, interval, jitter time.Duration, update func(Model) error) *Watcher {
+	return &Watcher{
+		regName:   regName,
+		repoName:  repoName,
+		tag:       tag,
+		modelsDir: modelsDir,
+		opts:      opts,
+		interval:  interval,
+		jitter:    jitter,
+		update:    update,
+		current:   current,
+	}
+}
+
+// Run poll registry until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	zap.S().Infof("watch %v/%v:%v every %v", w.regName, w.repoName, w.tag, w.interval)
+	for {
+		timer := time.NewTimer(w.nextPoll())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if w.Paused() {
+			zap.S().Debug("registry watcher paused, skip poll")
+			continue
+		}
+		if err := w.check(ctx); err != nil {
+			zap.S().Errorw("unable to roll out new model", "error", err)
+		}
+	}
+}
+
+func (w *Watcher) nextPoll() time.Duration {
+	if w.jitter <= 0 {
+		return w.interval
+	}
+	return w.interval + time.Duration(rand.Int63n(int64(w.jitter)))
+}
+
+// Pause stop polls until Resume is called
+func (w *Watcher) Pause() {
+	atomic.StoreInt32(&w.paused, 1)
+}
+
+func (w *Watcher) Resume() {
+	atomic.StoreInt32(&w.paused, 0)
+}
+
+func (w *Watcher) Paused() bool {
+	return atomic.LoadInt32(&w.paused) == 1
+}
+
+// Current return digest of running model
+func (w *Watcher) Current() digest.Digest {
+	w.muCurrent.Lock()
+	defer w.muCurrent.Unlock()
+	return w.current
+}
+
+// setCurrent record d as digest of running model, once model is switched
+func (w *Watcher) setCurrent(d digest.Digest) {
+	w.muCurrent.Lock()
+	defer w.muCurrent.Unlock()
+	w.current = d
+}
+
+// check resolve tag and roll out model it references when it isn't current one. A digest that fails is only tried
+// again once tag has moved
+func (w *Watcher) check(ctx context.Context) error {
+	repo, err := getRepository(ctx, w.regName, w.repoName, w.opts)
+	if err != nil {
+		return fmt.Errorf("unable to fetch oci artifact from '%s/%s: %w", w.regName, w.repoName, err)
+	}
+	desc, err := repo.Resolve(ctx, w.tag)
+	if err != nil {
+		// Registry may be temporarily unreachable, wait next poll
+		zap.S().Warnf("unable to resolve '%s/%s:%s': %v", w.regName, w.repoName, w.tag, err)
+		return nil
+	}
+	current := w.Current()
+	if desc.Digest == current || desc.Digest == w.failed {
+		return nil
+	}
+
+	zap.S().Infow("new model found",
+		"reference", fmt.Sprintf("%s/%s:%s", w.regName, w.repoName, w.tag),
+		"digest", desc.Digest,
+		"previous", current,
+	)
+	opts := w.opts
+	opts.RequireOnline = true
+	model, err := PullOciImage(ctx, w.regName, w.repoName+"@"+desc.Digest.String(), "", w.modelsDir, opts)
+	if err == nil {
+		err = w.update(model)
+	}
+	if err != nil {
+		w.failed = desc.Digest
+		return fmt.Errorf("unable to switch to model %v: %w", desc.Digest, err)
+	}
+	// Tag is pulled by digest, keep cache index up to date for offline startup
+	c := cache{dir: w.modelsDir}
+	if err := c.tag(fmt.Sprintf("%s/%s:%s", w.regName, w.repoName, w.tag), desc.Digest); err != nil {
+		zap.S().Warnf("unable to update models cache index: %v", err)
+	}
+	w.setCurrent(desc.Digest)
+	w.failed = ""
+	return nil
+}
@@ -0,0 +1,61 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWatcher_check(t *testing.T) {
+	server := newModelRegistry(t)
+	host := strings.TrimPrefix(server.URL, "http://")
+	modelsDir := t.TempDir()
+
+	var updates []Model
+	updateErr := fmt.Errorf("warm-up failed")
+	w := NewWatcher(host, "robocar/model", "v1", modelsDir, RegistryOptions{PlainHTTP: true}, "", 0, 0, func(m Model) error {
+		updates = append(updates, m)
+		return updateErr
+	})
+
+	if err := w.check(context.Background()); err == nil {
+		t.Errorf("check() with failing update, want error")
+	}
+	if err := w.check(context.Background()); err != nil {
+		t.Errorf("check() of failed digest error = %v", err)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("failed digest updated %v times, want 1", len(updates))
+	}
+
+	// Tag has moved since failure
+	updateErr = nil
+	w.failed = ""
+	if err := w.check(context.Background()); err != nil {
+		t.Fatalf("check() error = %v", err)
+	}
+	if len(updates) != 2 {
+		t.Fatalf("model updated %v times, want 2", len(updates))
+	}
+	if w.Current() != updates[1].Digest {
+		t.Errorf("current digest = %v, want %v", w.Current(), updates[1].Digest)
+	}
+	if d, err := (cache{dir: modelsDir}).resolve(host + "/robocar/model:v1"); err != nil || d != w.Current() {
+		t.Errorf("cached digest = %v, %v, want %v", d, err, w.Current())
+	}
+
+	if err := w.check(context.Background()); err != nil {
+		t.Errorf("check() of current digest error = %v", err)
+	}
+	if len(updates) != 2 {
+		t.Errorf("current model updated again")
+	}
+
+	// Registry is unreachable, wait next poll
+	server.Close()
+	w.setCurrent("")
+	if err := w.check(context.Background()); err != nil {
+		t.Errorf("check() of unreachable registry error = %v", err)
+	}
+}
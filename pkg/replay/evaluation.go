@@ -0,0 +1,82 @@
+package replay
+
+import (
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/stats"
+	"math"
+	"time"
+)
+
+// NewEvaluation compare predictions to ground truth. With bins > 0, a confusion matrix is computed using binFn to
+// find the bin of a steering value
+func NewEvaluation(bins int, binFn func(steering float32) int) *Evaluation {
+	e := &Evaluation{bins: bins, binFn: binFn}
+	if bins > 0 {
+		e.confusion = make([][]int, bins)
+		for i := range e.confusion {
+			e.confusion[i] = make([]int, bins)
+		}
+	}
+	return e
+}
+
+type Evaluation struct {
+	bins  int
+	binFn func(steering float32) int
+
+	samples   int
+	skipped   int
+	errors    int
+	absSum    float64
+	sqSum     float64
+	confusion [][]int
+	latencies stats.Durations
+}
+
+// Report is the evaluation result
+type Report struct {
+	Samples int     `json:"samples"`
+	Skipped int     `json:"skipped"`
+	Errors  int     `json:"errors"`
+	MAE     float64 `json:"mae"`
+	RMSE    float64 `json:"rmse"`
+	// Confusion is indexed by expected bin, then predicted bin
+	Confusion [][]int       `json:"confusion,omitempty"`
+	Latency   stats.Summary `json:"latency"`
+}
+
+// Add record a prediction and the steering expected
+func (e *Evaluation) Add(predicted, expected float32, latency time.Duration) {
+	e.samples += 1
+	diff := float64(predicted) - float64(expected)
+	e.absSum += math.Abs(diff)
+	e.sqSum += diff * diff
+	e.latencies.Add(latency)
+	if e.bins > 0 {
+		e.confusion[e.binFn(expected)][e.binFn(predicted)] += 1
+	}
+}
+
+// Skip record a record that can't be evaluated, without frame or steering
+func (e *Evaluation) Skip() {
+	e.skipped += 1
+}
+
+// Error record a failed inference
+func (e *Evaluation) Error() {
+	e.errors += 1
+}
+
+func (e *Evaluation) Report() Report {
+	r := Report{
+		Samples:   e.samples,
+		Skipped:   e.skipped,
+		Errors:    e.errors,
+		Confusion: e.confusion,
+		Latency:   e.latencies.Summary(),
+	}
+	if e.samples > 0 {
+		r.MAE = e.absSum / float64(e.samples)
+		r.RMSE = math.Sqrt(e.sqSum / float64(e.samples))
+	}
+	return r
+}
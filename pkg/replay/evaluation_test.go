@@ -0,0 +1,43 @@
+package replay
+
+import (
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEvaluation_Report(t *testing.T) {
+	e := NewEvaluation(3, func(steering float32) int {
+		return tools.Bin(float64(steering), 3, -1, 2.)
+	})
+	e.Add(-1., -1., 10*time.Millisecond)
+	e.Add(0.5, 1., 20*time.Millisecond)
+	e.Add(0., 1., 30*time.Millisecond)
+	e.Add(0., 0., 40*time.Millisecond)
+	e.Skip()
+	e.Error()
+
+	r := e.Report()
+	if r.Samples != 4 || r.Skipped != 1 || r.Errors != 1 {
+		t.Errorf("Report() counts = %v/%v/%v, want 4/1/1", r.Samples, r.Skipped, r.Errors)
+	}
+	if math.Abs(r.MAE-0.375) > 1e-6 {
+		t.Errorf("Report() MAE = %v, want 0.375", r.MAE)
+	}
+	if want := math.Sqrt(1.25 / 4); math.Abs(r.RMSE-want) > 1e-6 {
+		t.Errorf("Report() RMSE = %v, want %v", r.RMSE, want)
+	}
+	wantConfusion := [][]int{
+		{1, 0, 0},
+		{0, 1, 0},
+		{0, 1, 1},
+	}
+	if !reflect.DeepEqual(r.Confusion, wantConfusion) {
+		t.Errorf("Report() confusion = %v, want %v", r.Confusion, wantConfusion)
+	}
+	if r.Latency.Max != 40 {
+		t.Errorf("Report() max latency = %v, want 40", r.Latency.Max)
+	}
+}
@@ -0,0 +1,142 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// maxRecordSize protect against corrupted length prefix
+const maxRecordSize = 64 << 20
+
+// Reader read records from a stream of varint length-delimited RecordMessage
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read return next record, or io.EOF at end of stream
+func (r *Reader) Read() (*events.RecordMessage, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("unable to read record size: %w", err)
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("record size %d exceeds limit of %d bytes", size, maxRecordSize)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, fmt.Errorf("unable to read record: %w", err)
+	}
+	var msg events.RecordMessage
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal record: %w", err)
+	}
+	return &msg, nil
+}
+
+// Write append a length-delimited record to w
+func Write(w io.Writer, msg *events.RecordMessage) error {
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("unable to marshal record: %w", err)
+	}
+	size := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(size, uint64(len(b)))
+	if _, err := w.Write(size[:n]); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Walk call fn for each record found in paths. Directories are walked recursively in name order. When
+// recordPerFile is true, each file contains a single RecordMessage, else files are length-delimited streams
+func Walk(paths []string, recordPerFile bool, fn func(file string, msg *events.RecordMessage) error) error {
+	for _, path := range paths {
+		files, err := listFiles(path)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := walkFile(file, recordPerFile, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func walkFile(file string, recordPerFile bool, fn func(file string, msg *events.RecordMessage) error) error {
+	if recordPerFile {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("unable to read record file: %w", err)
+		}
+		var msg events.RecordMessage
+		if err := proto.Unmarshal(b, &msg); err != nil {
+			return fmt.Errorf("unable to unmarshal record file '%v': %w", file, err)
+		}
+		return fn(file, &msg)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("unable to open records file: %w", err)
+	}
+	defer f.Close()
+
+	r := NewReader(f)
+	for {
+		msg, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("bad records file '%v': %w", file, err)
+		}
+		if err := fn(file, msg); err != nil {
+			return err
+		}
+	}
+}
+
+func listFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	var files []string
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to walk directory '%v': %w", path, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
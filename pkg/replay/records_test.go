@@ -0,0 +1,118 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func record(id string, steering float32) *events.RecordMessage {
+	return &events.RecordMessage{
+		Frame:     &events.FrameMessage{Id: &events.FrameRef{Id: id}, Frame: []byte(id)},
+		Steering:  &events.SteeringMessage{Steering: steering, Confidence: 1.},
+		RecordSet: "session",
+	}
+}
+
+func TestReader_Read(t *testing.T) {
+	var buf bytes.Buffer
+	records := []*events.RecordMessage{record("1", -0.5), record("2", 0.), record("3", 1.)}
+	for _, r := range records {
+		if err := Write(&buf, r); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	r := NewReader(&buf)
+	for i, want := range records {
+		got, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read() record %d error = %v", i, err)
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("Read() = %v, want %v", got, want)
+		}
+	}
+	if _, err := r.Read(); !errors.Is(err, io.EOF) {
+		t.Errorf("Read() at end of stream error = %v, want io.EOF", err)
+	}
+}
+
+func TestReader_Read_truncated(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, record("1", 0.)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	r := NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-2]))
+	if _, err := r.Read(); err == nil || errors.Is(err, io.EOF) {
+		t.Errorf("Read() of truncated record error = %v, want read error", err)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	dir := t.TempDir()
+
+	// Delimited stream
+	var buf bytes.Buffer
+	for _, r := range []*events.RecordMessage{record("b1", 0.), record("b2", 0.)} {
+		if err := Write(&buf, r); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.records"), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("unable to write records: %v", err)
+	}
+	buf.Reset()
+	if err := Write(&buf, record("a1", 0.)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.records"), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("unable to write records: %v", err)
+	}
+
+	// One record per file
+	perFileDir := filepath.Join(t.TempDir(), "session")
+	if err := os.MkdirAll(perFileDir, 0755); err != nil {
+		t.Fatalf("unable to create directory: %v", err)
+	}
+	for _, id := range []string{"2", "1"} {
+		b, err := proto.Marshal(record(id, 0.))
+		if err != nil {
+			t.Fatalf("unable to marshal record: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(perFileDir, "record_"+id+".pb"), b, 0644); err != nil {
+			t.Fatalf("unable to write record: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		path          string
+		recordPerFile bool
+		want          []string
+	}{
+		{name: "delimited", path: dir, want: []string{"a1", "b1", "b2"}},
+		{name: "record per file", path: perFileDir, recordPerFile: true, want: []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := Walk([]string{tt.path}, tt.recordPerFile, func(_ string, msg *events.RecordMessage) error {
+				got = append(got, msg.GetFrame().GetId().GetId())
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Walk() records = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
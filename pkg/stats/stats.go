@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Durations collect durations to compute their distribution
+type Durations struct {
+	values []time.Duration
+}
+
+func (d *Durations) Add(v time.Duration) {
+	d.values = append(d.values, v)
+}
+
+func (d *Durations) Len() int {
+	return len(d.values)
+}
+
+// Summary is the distribution of durations, values are in milliseconds
+type Summary struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+func (d *Durations) Summary() Summary {
+	if len(d.values) == 0 {
+		return Summary{}
+	}
+	sorted := make([]time.Duration, len(d.values))
+	copy(sorted, d.values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, v := range sorted {
+		sum += v
+	}
+	return Summary{
+		Count: len(sorted),
+		Mean:  milliseconds(sum / time.Duration(len(sorted))),
+		P50:   milliseconds(percentile(sorted, 50)),
+		P90:   milliseconds(percentile(sorted, 90)),
+		P99:   milliseconds(percentile(sorted, 99)),
+		Max:   milliseconds(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile use nearest-rank method on sorted values
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
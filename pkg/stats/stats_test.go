@@ -0,0 +1,21 @@
+package stats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurations_Summary(t *testing.T) {
+	var d Durations
+	if got := d.Summary(); got != (Summary{}) {
+		t.Errorf("Summary() of empty durations = %v, want zero value", got)
+	}
+
+	for i := 100; i >= 1; i-- {
+		d.Add(time.Duration(i) * time.Millisecond)
+	}
+	want := Summary{Count: 100, Mean: 50.5, P50: 50, P90: 90, P99: 99, Max: 100}
+	if got := d.Summary(); got != want {
+		t.Errorf("Summary() = %+v, want %+v", got, want)
+	}
+}
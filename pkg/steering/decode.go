@@ -0,0 +1,217 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"strconv"
+	"strings"
+)
+
+// Decoding settings names, as used by Decoding.Set and oci annotations
+const (
+	DecodingDecoder        = "decoder"
+	DecodingTopK           = "top_k"
+	DecodingConfidence     = "confidence"
+	DecodingSteeringOffset = "steering_offset"
+	DecodingSteeringRange  = "steering_range"
+	DecodingThrottleOffset = "throttle_offset"
+	DecodingThrottleRange  = "throttle_range"
+)
+
+// DecodingSettings list settings accepted by Decoding.Set
+var DecodingSettings = []string{
+	DecodingDecoder,
+	DecodingTopK,
+	DecodingConfidence,
+	DecodingSteeringOffset,
+	DecodingSteeringRange,
+	DecodingThrottleOffset,
+	DecodingThrottleRange,
+}
+
+// Decoder compute value of categorical outputs from bins scores
+type Decoder int
+
+const (
+	// DecoderArgMax take the most probable bin
+	DecoderArgMax Decoder = iota
+	// DecoderExpectation take the mean of bins weighted by their probability
+	DecoderExpectation
+	// DecoderTopK take the mean of the k most probable bins weighted by their probability
+	DecoderTopK
+)
+
+func ParseDecoder(s string) (Decoder, error) {
+	switch strings.ToLower(s) {
+	case "argmax":
+		return DecoderArgMax, nil
+	case "expectation":
+		return DecoderExpectation, nil
+	case "topk", "top_k":
+		return DecoderTopK, nil
+	default:
+		return DecoderArgMax, fmt.Errorf("unknown decoder '%v'", s)
+	}
+}
+
+func (d Decoder) String() string {
+	switch d {
+	case DecoderArgMax:
+		return "argmax"
+	case DecoderExpectation:
+		return "expectation"
+	case DecoderTopK:
+		return "topk"
+	default:
+		return "unknown"
+	}
+}
+
+// Confidence compute confidence of categorical outputs
+type Confidence int
+
+const (
+	// ConfidenceScore use score of the most probable bin, or probabilities sum of bins used by top-k decoder
+	ConfidenceScore Confidence = iota
+	// ConfidenceEntropy use 1 - normalized entropy of bins probabilities
+	ConfidenceEntropy
+)
+
+func ParseConfidence(s string) (Confidence, error) {
+	switch strings.ToLower(s) {
+	case "score":
+		return ConfidenceScore, nil
+	case "entropy":
+		return ConfidenceEntropy, nil
+	default:
+		return ConfidenceScore, fmt.Errorf("unknown confidence '%v'", s)
+	}
+}
+
+func (c Confidence) String() string {
+	switch c {
+	case ConfidenceScore:
+		return "score"
+	case ConfidenceEntropy:
+		return "entropy"
+	default:
+		return "unknown"
+	}
+}
+
+// Decoding describe how categorical outputs are decoded. Number of bins comes from output shape, bin b match value
+// b * Range / (bins + Offset) + Offset, as donkeycar linear_unbin
+type Decoding struct {
+	Decoder    Decoder
+	TopK       int
+	Confidence Confidence
+
+	SteeringOffset float64
+	SteeringRange  float64
+	ThrottleOffset float64
+	ThrottleRange  float64
+}
+
+// DefaultDecoding take most probable bin, with steering and throttle bins as trained by donkeycar
+func DefaultDecoding() Decoding {
+	return Decoding{
+		Decoder:        DecoderArgMax,
+		TopK:           3,
+		Confidence:     ConfidenceScore,
+		SteeringOffset: -1.,
+		SteeringRange:  2.,
+		ThrottleOffset: 0.,
+		ThrottleRange:  0.5,
+	}
+}
+
+// Set update setting from its text value:
+//   - decoder: argmax, expectation or topk
+//   - top_k: number of bins used by topk decoder
+//   - confidence: score or entropy
+//   - steering_offset, steering_range, throttle_offset and throttle_range: bins parameters
+func (d *Decoding) Set(name, value string) error {
+	switch name {
+	case DecodingDecoder:
+		v, err := ParseDecoder(value)
+		if err != nil {
+			return err
+		}
+		d.Decoder = v
+	case DecodingTopK:
+		v, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || v < 1 {
+			return fmt.Errorf("bad top k '%v', expected a positive integer", value)
+		}
+		d.TopK = v
+	case DecodingConfidence:
+		v, err := ParseConfidence(value)
+		if err != nil {
+			return err
+		}
+		d.Confidence = v
+	case DecodingSteeringOffset, DecodingSteeringRange, DecodingThrottleOffset, DecodingThrottleRange:
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return fmt.Errorf("bad %v '%v': %w", name, value, err)
+		}
+		switch name {
+		case DecodingSteeringOffset:
+			d.SteeringOffset = v
+		case DecodingSteeringRange:
+			d.SteeringRange = v
+		case DecodingThrottleOffset:
+			d.ThrottleOffset = v
+		case DecodingThrottleRange:
+			d.ThrottleRange = v
+		}
+	default:
+		return fmt.Errorf("unknown decoding setting '%v'", name)
+	}
+	return nil
+}
+
+// Validate check decoding consistency
+func (d *Decoding) Validate() error {
+	if d.Decoder == DecoderTopK && d.TopK < 1 {
+		return fmt.Errorf("invalid top k %d", d.TopK)
+	}
+	if d.SteeringRange <= 0 || d.ThrottleRange <= 0 {
+		return fmt.Errorf("bins ranges must be positive")
+	}
+	return nil
+}
+
+func (d *Decoding) String() string {
+	decoder := d.Decoder.String()
+	if d.Decoder == DecoderTopK {
+		decoder = fmt.Sprintf("top %d", d.TopK)
+	}
+	return fmt.Sprintf("%v, confidence %v, steering bins offset %v range %v, throttle bins offset %v range %v",
+		decoder, d.Confidence, d.SteeringOffset, d.SteeringRange, d.ThrottleOffset, d.ThrottleRange)
+}
+
+// decode categorical scores, one by bin, into value and confidence. probs is a buffer of len(scores)
+func (d *Decoding) decode(scores []float32, probs []float64, offset, r float64) (float64, float64) {
+	n := len(scores)
+	var bin, confidence float64
+	switch d.Decoder {
+	case DecoderExpectation:
+		tools.Probabilities(scores, probs)
+		bin = tools.Expectation(probs)
+		confidence = probs[tools.ArgMax(scores)]
+	case DecoderTopK:
+		tools.Probabilities(scores, probs)
+		bin, confidence = tools.TopK(probs, d.TopK)
+	default:
+		b := tools.ArgMax(scores)
+		bin, confidence = float64(b), float64(scores[b])
+	}
+	if d.Confidence == ConfidenceEntropy {
+		if d.Decoder == DecoderArgMax {
+			tools.Probabilities(scores, probs)
+		}
+		confidence = tools.EntropyConfidence(probs)
+	}
+	return tools.Unbin(bin, n, offset, r), confidence
+}
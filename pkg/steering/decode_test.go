@@ -0,0 +1,67 @@
+package steering
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDecoding_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string
+		value   string
+		check   func(d Decoding) bool
+		wantErr bool
+	}{
+		{name: "decoder", setting: DecodingDecoder, value: "Expectation", check: func(d Decoding) bool { return d.Decoder == DecoderExpectation }},
+		{name: "unknown decoder", setting: DecodingDecoder, value: "magic", wantErr: true},
+		{name: "top k", setting: DecodingTopK, value: "5", check: func(d Decoding) bool { return d.TopK == 5 }},
+		{name: "bad top k", setting: DecodingTopK, value: "0", wantErr: true},
+		{name: "confidence", setting: DecodingConfidence, value: "entropy", check: func(d Decoding) bool { return d.Confidence == ConfidenceEntropy }},
+		{name: "steering range", setting: DecodingSteeringRange, value: "1.5", check: func(d Decoding) bool { return d.SteeringRange == 1.5 }},
+		{name: "bad throttle offset", setting: DecodingThrottleOffset, value: "a", wantErr: true},
+		{name: "unknown setting", setting: "temperature", value: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DefaultDecoding()
+			err := d.Set(tt.setting, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(d) {
+				t.Errorf("Set() result = %v", d.String())
+			}
+		})
+	}
+}
+
+func TestDecoding_decode(t *testing.T) {
+	// 5 bins over [-1, 1]: -1, -0.5, 0, 0.5, 1
+	scores := []float32{0., 0.2, 0.5, 0.3, 0.}
+	tests := []struct {
+		name           string
+		settings       map[string]string
+		want           float64
+		wantConfidence float64
+	}{
+		{name: "argmax", want: 0., wantConfidence: 0.5},
+		{name: "expectation", settings: map[string]string{DecodingDecoder: "expectation"}, want: 0.05, wantConfidence: 0.5},
+		{name: "top 2", settings: map[string]string{DecodingDecoder: "topk", DecodingTopK: "2"}, want: 0.1875, wantConfidence: 0.8},
+		{name: "entropy", settings: map[string]string{DecodingConfidence: "entropy"}, want: 0., wantConfidence: 1 - 1.0297/math.Log(5)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := DefaultDecoding()
+			for k, v := range tt.settings {
+				if err := d.Set(k, v); err != nil {
+					t.Fatalf("Set(%v, %v) error = %v", k, v, err)
+				}
+			}
+			got, confidence := d.decode(scores, make([]float64, len(scores)), d.SteeringOffset, d.SteeringRange)
+			if math.Abs(got-tt.want) > 1e-3 || math.Abs(confidence-tt.wantConfidence) > 1e-3 {
+				t.Errorf("decode() = %v, %v, want %v, %v", got, confidence, tt.want, tt.wantConfidence)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package steering
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Combination define how predictions of ensemble members are combined
+type Combination int
+
+const (
+	// CombineAverage compute the weighted average of members values and confidences
+	CombineAverage Combination = iota
+	// CombineConfidenceMax take prediction of the most confident member
+	CombineConfidenceMax
+	// CombineMedian take the median of members values and confidences
+	CombineMedian
+)
+
+func ParseCombination(s string) (Combination, error) {
+	switch strings.ToLower(s) {
+	case "average":
+		return CombineAverage, nil
+	case "confidence-max":
+		return CombineConfidenceMax, nil
+	case "median":
+		return CombineMedian, nil
+	default:
+		return CombineAverage, fmt.Errorf("unknown combination '%v'", s)
+	}
+}
+
+func (c Combination) String() string {
+	switch c {
+	case CombineAverage:
+		return "average"
+	case CombineConfidenceMax:
+		return "confidence-max"
+	case CombineMedian:
+		return "median"
+	default:
+		return "unknown"
+	}
+}
+
+// Member is a model of an ensemble
+type Member struct {
+	Model *Model
+	// Weight of member predictions for average combination
+	Weight float32
+	// Topic publish member steering, for debug, empty to disable
+	Topic string
+}
+
+// NewEnsemble combine predictions of members with combination method
+func NewEnsemble(combination Combination, members ...Member) *Ensemble {
+	return &Ensemble{
+		combination: combination,
+		members:     members,
+		histories:   make([]*frameHistory, len(members)),
+	}
+}
+
+// Ensemble run many models on the same frame and combine their predictions
+type Ensemble struct {
+	combination Combination
+	members     []Member
+	// histories of members that expect many frames
+	histories []*frameHistory
+}
+
+// memberResult is the prediction of a member for a frame
+type memberResult struct {
+	prediction Prediction
+	err        error
+}
+
+// Load all member models. Members that expect many frames reset their history when 2 frames are created more than
+// frameGap apart
+func (e *Ensemble) Load(frameGap time.Duration) error {
+	if len(e.members) == 0 {
+		return fmt.Errorf("ensemble has no member")
+	}
+	for i, m := range e.members {
+		if m.Weight <= 0 {
+			return fmt.Errorf("invalid weight %v for model %v", m.Weight, m.Model.Path())
+		}
+		if err := m.Model.Load(); err != nil {
+			return fmt.Errorf("unable to load ensemble model %v: %w", m.Model.Path(), err)
+		}
+		if m.Model.Frames() > 1 {
+			e.histories[i] = m.Model.newFrameHistory(frameGap)
+		}
+		zap.S().Infof("ensemble member %d: %v, weight %v", i, m.Model.Path(), m.Weight)
+	}
+	zap.S().Infof("ensemble predictions combined by %v", e.combination)
+	return nil
+}
+
+// Close release members engines
+func (e *Ensemble) Close() {
+	for _, m := range e.members {
+		m.Model.Close()
+	}
+}
+
+// predict run all members concurrently on img, created at date at, and combine their predictions. Failing members
+// are ignored, an error is returned only when all members fail
+func (e *Ensemble) predict(img image.Image, at time.Time) (Prediction, []memberResult, error) {
+	results := make([]memberResult, len(e.members))
+	var wg sync.WaitGroup
+	for i := range e.members {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].prediction, results[i].err = e.members[i].Model.predict(img, e.histories[i], at, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	var steering, throttle []Prediction
+	var steeringWeights, throttleWeights []float32
+	for i, r := range results {
+		if r.err != nil {
+			zap.S().Warnf("ensemble model %v failed: %v", e.members[i].Model.Path(), r.err)
+			continue
+		}
+		steering = append(steering, r.prediction)
+		steeringWeights = append(steeringWeights, e.members[i].Weight)
+		if r.prediction.HasThrottle {
+			throttle = append(throttle, r.prediction)
+			throttleWeights = append(throttleWeights, e.members[i].Weight)
+		}
+	}
+	if len(steering) == 0 {
+		return Prediction{}, results, fmt.Errorf("all ensemble models failed, last error: %w", results[len(results)-1].err)
+	}
+
+	var prediction Prediction
+	prediction.Steering, prediction.SteeringConfidence = combine(e.combination, steeringWeights, steering,
+		func(p Prediction) (float32, float32) { return p.Steering, p.SteeringConfidence })
+	if len(throttle) > 0 {
+		prediction.HasThrottle = true
+		prediction.Throttle, prediction.ThrottleConfidence = combine(e.combination, throttleWeights, throttle,
+			func(p Prediction) (float32, float32) { return p.Throttle, p.ThrottleConfidence })
+	}
+	return prediction, results, nil
+}
+
+// combine values and confidences returned by value for each prediction
+func combine(c Combination, weights []float32, predictions []Prediction, value func(p Prediction) (float32, float32)) (float32, float32) {
+	values := make([]float32, len(predictions))
+	confidences := make([]float32, len(predictions))
+	for i, p := range predictions {
+		values[i], confidences[i] = value(p)
+	}
+
+	switch c {
+	case CombineConfidenceMax:
+		best := 0
+		for i := range confidences {
+			if confidences[i] > confidences[best] {
+				best = i
+			}
+		}
+		return values[best], confidences[best]
+	case CombineMedian:
+		return median(values), median(confidences)
+	default:
+		var v, conf, total float32
+		for i, w := range weights {
+			v += w * values[i]
+			conf += w * confidences[i]
+			total += w
+		}
+		return v / total, conf / total
+	}
+}
+
+// median sort values and return their median
+func median(values []float32) float32 {
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
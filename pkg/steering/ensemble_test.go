@@ -0,0 +1,119 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"google.golang.org/protobuf/proto"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_combine(t *testing.T) {
+	predictions := []Prediction{
+		{Steering: -1., SteeringConfidence: 0.2},
+		{Steering: 0.5, SteeringConfidence: 0.9},
+		{Steering: 0.2, SteeringConfidence: 0.4},
+	}
+	tests := []struct {
+		name           string
+		combination    Combination
+		weights        []float32
+		want           float32
+		wantConfidence float32
+	}{
+		{name: "average", combination: CombineAverage, weights: []float32{1, 1, 1}, want: -0.1, wantConfidence: 0.5},
+		{name: "weighted average", combination: CombineAverage, weights: []float32{2, 1, 1}, want: -0.325, wantConfidence: 0.425},
+		{name: "confidence max", combination: CombineConfidenceMax, weights: []float32{1, 1, 1}, want: 0.5, wantConfidence: 0.9},
+		{name: "median", combination: CombineMedian, weights: []float32{1, 1, 1}, want: 0.2, wantConfidence: 0.4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, confidence := combine(tt.combination, tt.weights, predictions,
+				func(p Prediction) (float32, float32) { return p.Steering, p.SteeringConfidence })
+			if math.Abs(float64(got-tt.want)) > 1e-6 || math.Abs(float64(confidence-tt.wantConfidence)) > 1e-6 {
+				t.Errorf("combine() = %v, %v, want %v, %v", got, confidence, tt.want, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+func Test_median_even(t *testing.T) {
+	if got := median([]float32{1, -1, 0.5, 0}); got != 0.25 {
+		t.Errorf("median() = %v, want 0.25", got)
+	}
+}
+
+func newTestMember(t *testing.T, modelType tools.ModelType, output engine.Tensor, script fake.Script, topic string) Member {
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{output}, script)
+	m := NewModel(eng, modelType, fmt.Sprintf("model_%v.tflite", modelType), DefaultPreprocessing(imgWidth, imgHeight, horizon), DefaultDecoding(), "", "")
+	return Member{Model: m, Weight: 1., Topic: topic}
+}
+
+func TestPart_processFrame_ensemble(t *testing.T) {
+	msgs := recordPublish(t)
+	failing := func(_ int, _ [][]byte) ([][]byte, error) {
+		return nil, fmt.Errorf("inference failure")
+	}
+	tests := []struct {
+		name    string
+		members []Member
+		want    map[string]float32
+	}{
+		{
+			name: "average",
+			members: []Member{
+				newTestMember(t, tools.ModelTypeCategorical, categoricalTensor, fake.Fixed(bins(14)), "debug/categorical"),
+				newTestMember(t, tools.ModelTypeLinear, linearTensor, fake.Fixed([]byte{128}), "debug/linear"),
+			},
+			want: map[string]float32{"steering": 0.5, "debug/categorical": 1., "debug/linear": 0.},
+		},
+		{
+			name: "failing member is ignored",
+			members: []Member{
+				newTestMember(t, tools.ModelTypeCategorical, categoricalTensor, fake.Fixed(bins(0)), ""),
+				newTestMember(t, tools.ModelTypeLinear, linearTensor, failing, "debug/linear"),
+			},
+			want: map[string]float32{"steering": -1.},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*msgs = nil
+			e := NewEnsemble(CombineAverage, tt.members...)
+			if err := e.Load(time.Second); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			p := NewEnsemblePart(nil, e, "steering", "camera")
+
+			var msg events.FrameMessage
+			if err := proto.Unmarshal(framePayload(t, "1"), &msg); err != nil {
+				t.Fatalf("unable to unmarshal frame: %v", err)
+			}
+			p.processFrame(&msg)
+
+			got := make(map[string]float32)
+			for _, m := range *msgs {
+				var steeringMsg events.SteeringMessage
+				if err := proto.Unmarshal(m.payload, &steeringMsg); err != nil {
+					t.Fatalf("unable to unmarshal steering message: %v", err)
+				}
+				if steeringMsg.GetFrameRef().GetId() != "1" {
+					t.Errorf("steering message on %v for frame %v, want 1", m.topic, steeringMsg.GetFrameRef().GetId())
+				}
+				got[m.topic] = steeringMsg.Steering
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("published on topics %v, want %v", got, tt.want)
+			}
+			for topic, want := range tt.want {
+				if math.Abs(float64(got[topic]-want)) > 0.01 {
+					t.Errorf("steering on %v = %v, want %v", topic, got[topic], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// defaultFrameGap is the default max duration between 2 stacked frames
+const defaultFrameGap = 500 * time.Millisecond
+
+// stackLayout describe how frames are stacked into input tensor
+type stackLayout struct {
+	// frames is the number of frames expected by model
+	frames int
+	// interleaved is true when frames are stacked on channels axis ([1, H, W, N*C]), false when stacked on a time
+	// axis ([1, N, H, W, C])
+	interleaved bool
+	// pixels is the number of pixels of a frame
+	pixels int
+	// pixelSize is the number of bytes of a pixel of a single frame
+	pixelSize int
+}
+
+// frameSize is the number of bytes of a single frame
+func (l stackLayout) frameSize() int {
+	return l.pixels * l.pixelSize
+}
+
+// newStackLayout find frames stacking from input shape. Shapes [1, H, W, C] are single frame, [1, H, W, N*C] are
+// frames stacked on channels and [1, N, H, W, C] frames on time axis. Other shapes are considered as single frame
+func newStackLayout(input engine.Tensor, width, height, channels int) (stackLayout, error) {
+	input, _ = withDefaultQuantization(input)
+	l := stackLayout{frames: 1, pixels: width * height, pixelSize: channels * input.Type.Size()}
+
+	shape := input.Shape
+	switch len(shape) {
+	case 4:
+		if shape[1] != height || shape[2] != width {
+			return l, fmt.Errorf("input tensor %v doesn't match image size %dx%d", input, width, height)
+		}
+		if shape[3]%channels != 0 {
+			return l, fmt.Errorf("input tensor %v doesn't have a multiple of %d channels", input, channels)
+		}
+		l.frames = shape[3] / channels
+		l.interleaved = l.frames > 1
+	case 5:
+		if shape[2] != height || shape[3] != width || shape[4] != channels {
+			return l, fmt.Errorf("input tensor %v doesn't match image size %dx%d with %d channels", input, width, height, channels)
+		}
+		l.frames = shape[1]
+	}
+	if l.frames < 1 {
+		return l, fmt.Errorf("input tensor %v has no frame", input)
+	}
+	return l, nil
+}
+
+func newFrameHistory(layout stackLayout, maxGap time.Duration) *frameHistory {
+	h := &frameHistory{
+		layout:  layout,
+		maxGap:  maxGap,
+		frames:  make([][]byte, layout.frames),
+		ordered: make([][]byte, layout.frames),
+	}
+	for i := range h.frames {
+		h.frames[i] = make([]byte, layout.frameSize())
+	}
+	return h
+}
+
+// frameHistory keep last preprocessed frames to feed models that expect a sequence of frames. History is reset when
+// frames are too far apart
+type frameHistory struct {
+	mu     sync.Mutex
+	layout stackLayout
+	maxGap time.Duration
+
+	// frames is a ring buffer, next is the slot of next frame
+	frames [][]byte
+	next   int
+	count  int
+	last   time.Time
+	// ordered is reused to sort frames, oldest first
+	ordered [][]byte
+}
+
+// add pack frame created at date at into history, then write stacked frames, oldest first, into dst. Missing
+// frames, after a reset, are replaced by oldest frame available
+func (h *frameHistory) add(at time.Time, pack func(dst []byte) error, dst []byte) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.count > 0 && (at.Before(h.last) || (h.maxGap > 0 && at.Sub(h.last) > h.maxGap)) {
+		zap.L().Debug("reset frame history",
+			zap.Time("last", h.last),
+			zap.Time("frame", at),
+		)
+		h.reset()
+	}
+
+	if err := pack(h.frames[h.next]); err != nil {
+		// Slot may be partially overwritten
+		h.reset()
+		return err
+	}
+	h.next = (h.next + 1) % len(h.frames)
+	if h.count < len(h.frames) {
+		h.count += 1
+	}
+	h.last = at
+
+	h.assemble(dst)
+	return nil
+}
+
+func (h *frameHistory) reset() {
+	h.next = 0
+	h.count = 0
+	h.last = time.Time{}
+}
+
+// frame return the i-th frame of stack, 0 is the oldest
+func (h *frameHistory) frame(i int) []byte {
+	n := len(h.frames)
+	missing := n - h.count
+	if i < missing {
+		i = missing
+	}
+	// Oldest available frame is at next slot when ring is full, at 0 otherwise
+	oldest := (h.next - h.count + n) % n
+	return h.frames[(oldest+i-missing)%n]
+}
+
+func (h *frameHistory) assemble(dst []byte) {
+	l := h.layout
+	for i := range h.ordered {
+		h.ordered[i] = h.frame(i)
+	}
+	if !l.interleaved {
+		for i, f := range h.ordered {
+			copy(dst[i*l.frameSize():], f)
+		}
+		return
+	}
+
+	o := 0
+	for p := 0; p < l.pixels; p++ {
+		start := p * l.pixelSize
+		for _, f := range h.ordered {
+			o += copy(dst[o:o+l.pixelSize], f[start:start+l.pixelSize])
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package steering
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_newStackLayout(t *testing.T) {
+	tests := []struct {
+		name    string
+		shape   []int
+		want    stackLayout
+		wantErr bool
+	}{
+		{name: "single frame", shape: []int{1, 2, 4, 3}, want: stackLayout{frames: 1, pixels: 8, pixelSize: 3}},
+		{name: "stacked channels", shape: []int{1, 2, 4, 9}, want: stackLayout{frames: 3, interleaved: true, pixels: 8, pixelSize: 3}},
+		{name: "time axis", shape: []int{1, 5, 2, 4, 3}, want: stackLayout{frames: 5, pixels: 8, pixelSize: 3}},
+		{name: "bad size", shape: []int{1, 4, 2, 3}, wantErr: true},
+		{name: "bad channels", shape: []int{1, 2, 4, 4}, wantErr: true},
+		{name: "bad time axis channels", shape: []int{1, 5, 2, 4, 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newStackLayout(engine.Tensor{Type: engine.TensorTypeUInt8, Shape: tt.shape}, 4, 2, 3)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newStackLayout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("newStackLayout() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_frameHistory_add(t *testing.T) {
+	// Frames of 2 pixels of 1 byte, pixels values are frame value
+	fill := func(v byte) func(dst []byte) error {
+		return func(dst []byte) error {
+			for i := range dst {
+				dst[i] = v
+			}
+			return nil
+		}
+	}
+	t0 := time.Now()
+	type frame struct {
+		value byte
+		at    time.Duration
+	}
+	tests := []struct {
+		name        string
+		interleaved bool
+		frames      []frame
+		want        []byte
+	}{
+		{name: "missing frames are padded", frames: []frame{{1, 0}, {2, 10 * time.Millisecond}}, want: []byte{1, 1, 1, 1, 2, 2}},
+		{name: "oldest first", frames: []frame{{1, 0}, {2, 1}, {3, 2}, {4, 3}}, want: []byte{2, 2, 3, 3, 4, 4}},
+		{name: "interleaved", interleaved: true, frames: []frame{{1, 0}, {2, 1}, {3, 2}}, want: []byte{1, 2, 3, 1, 2, 3}},
+		{name: "reset on gap", frames: []frame{{1, 0}, {2, 1}, {3, time.Second}}, want: []byte{3, 3, 3, 3, 3, 3}},
+		{name: "reset on older frame", frames: []frame{{1, 10}, {2, 11}, {3, 5}}, want: []byte{3, 3, 3, 3, 3, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newFrameHistory(stackLayout{frames: 3, interleaved: tt.interleaved, pixels: 2, pixelSize: 1}, 100*time.Millisecond)
+			dst := make([]byte, 6)
+			for _, f := range tt.frames {
+				if err := h.add(t0.Add(f.at), fill(f.value), dst); err != nil {
+					t.Fatalf("add() error = %v", err)
+				}
+			}
+			if !reflect.DeepEqual(dst, tt.want) {
+				t.Errorf("add() stack = %v, want %v", dst, tt.want)
+			}
+		})
+	}
+}
+
+func solidFrame(t *testing.T, id string, c color.Gray, createdAt time.Time) *events.FrameMessage {
+	img := image.NewGray(image.Rect(0, 0, imgWidth, imgHeight))
+	for i := range img.Pix {
+		img.Pix[i] = c.Y
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("unable to encode frame: %v", err)
+	}
+	return &events.FrameMessage{
+		Id:    &events.FrameRef{Name: "camera", Id: id, CreatedAt: timestamppb.New(createdAt)},
+		Frame: buf.Bytes(),
+	}
+}
+
+func TestPart_processFrame_stacked(t *testing.T) {
+	msgs := recordPublish(t)
+	const frames = 2
+	input := engine.Tensor{
+		Name:         "input",
+		Type:         engine.TensorTypeUInt8,
+		Shape:        []int{1, frames, imgHeight - horizon, imgWidth, 3},
+		Quantization: engine.Quantization{Scale: 1. / 255.},
+	}
+	frameSize := input.ByteSize() / frames
+	eng := fake.New([]engine.Tensor{input}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(7)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithFrameGap(time.Second))
+	if p.model.Frames() != frames {
+		t.Fatalf("Frames() = %v, want %v", p.model.Frames(), frames)
+	}
+
+	t0 := time.Now()
+	black, white := color.Gray{Y: 0}, color.Gray{Y: 255}
+	p.processFrame(solidFrame(t, "1", black, t0))
+	p.processFrame(solidFrame(t, "2", white, t0.Add(50*time.Millisecond)))
+	p.processFrame(solidFrame(t, "3", black, t0.Add(2*time.Second)))
+
+	// First byte of each frame of stack
+	want := [][]byte{{0, 0}, {0, 255}, {0, 0}}
+	calls := eng.Calls()
+	if len(calls) != len(want) {
+		t.Fatalf("model invoked %d times, want %d", len(calls), len(want))
+	}
+	for i, c := range calls {
+		got := []byte{c[0][0], c[0][frameSize]}
+		for j := range got {
+			if diff := int(got[j]) - int(want[i][j]); diff > 2 || diff < -2 {
+				t.Errorf("call %d: stacked frames = %v, want %v", i, got, want[i])
+				break
+			}
+		}
+	}
+	if len(*msgs) != len(want) {
+		t.Errorf("published %d messages, want %d", len(*msgs), len(want))
+	}
+}
+
+func TestPart_runWorker_stacked(t *testing.T) {
+	recordPublish(t)
+	const frames = 2
+	input := engine.Tensor{
+		Name:         "input",
+		Type:         engine.TensorTypeUInt8,
+		Shape:        []int{1, frames, imgHeight - horizon, imgWidth, 3},
+		Quantization: engine.Quantization{Scale: 1. / 255.},
+	}
+	frameSize := input.ByteSize() / frames
+	eng := fake.New([]engine.Tensor{input}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(7)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithFrameGap(time.Second), WithWorkers(2))
+
+	p.limitWorkers()
+	if p.workers != 1 {
+		t.Fatalf("workers = %v, want 1 for model that expects %d frames", p.workers, frames)
+	}
+
+	cancel := make(chan interface{})
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.runWorker(cancel)
+		}()
+	}
+
+	t0 := time.Now()
+	black, white := color.Gray{Y: 0}, color.Gray{Y: 255}
+	colors := []color.Gray{black, white, black, white}
+	deadline := time.Now().Add(5 * time.Second)
+	for i, c := range colors {
+		p.frames.put(solidFrame(t, fmt.Sprint(i), c, t0.Add(time.Duration(i)*50*time.Millisecond)))
+		for len(eng.Calls()) <= i && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(cancel)
+	wg.Wait()
+
+	// First byte of each frame of stack, history is never reset
+	want := [][]byte{{0, 0}, {0, 255}, {255, 0}, {0, 255}}
+	calls := eng.Calls()
+	if len(calls) != len(want) {
+		t.Fatalf("model invoked %d times, want %d", len(calls), len(want))
+	}
+	for i, c := range calls {
+		got := []byte{c[0][0], c[0][frameSize]}
+		for j := range got {
+			if diff := int(got[j]) - int(want[i][j]); diff > 2 || diff < -2 {
+				t.Errorf("call %d: stacked frames = %v, want %v", i, got, want[i])
+				break
+			}
+		}
+	}
+}
+
+func TestModel_Predict_stackedWithoutHistory(t *testing.T) {
+	input := engine.Tensor{Name: "input", Type: engine.TensorTypeUInt8, Shape: []int{1, imgHeight - horizon, imgWidth, 6}}
+	eng := fake.New([]engine.Tensor{input}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(7)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical)
+	if _, err := p.model.Predict(image.NewRGBA(image.Rect(0, 0, imgWidth, imgHeight))); err == nil {
+		t.Errorf("Predict() of stacked model without history, want error")
+	}
+}
@@ -0,0 +1,314 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"go.uber.org/zap"
+	"image"
+	"sync"
+	"time"
+)
+
+// NewModel instantiate model run by eng. steeringOutput and throttleOutput select output tensors to use, by index or
+// name, empty value search a tensor by name
+func NewModel(eng engine.Engine, modelType tools.ModelType, modelPath string, pre Preprocessing, dec Decoding, steeringOutput, throttleOutput string) *Model {
+	return &Model{
+		engine:         eng,
+		modelType:      modelType,
+		modelPath:      modelPath,
+		pre:            pre,
+		dec:            dec,
+		steeringOutput: steeringOutput,
+		throttleOutput: throttleOutput,
+	}
+}
+
+// Model compute steering from images
+type Model struct {
+	engine    engine.Engine
+	modelType tools.ModelType
+	modelPath string
+
+	steeringOutput string
+	throttleOutput string
+	steeringIdx    int
+	// throttleIdx is negative when model has no throttle output
+	throttleIdx int
+
+	pre    Preprocessing
+	dec    Decoding
+	packer *packer
+	layout stackLayout
+	// buffers reuse tensor buffers between predictions
+	buffers sync.Pool
+}
+
+// buffers hold tensor buffers of a prediction
+type buffers struct {
+	inputs  [][]byte
+	outputs [][]byte
+	values  [][]float32
+	probs   [][]float64
+}
+
+// Load model into engine
+func (m *Model) Load() error {
+	if err := m.pre.Validate(); err != nil {
+		return fmt.Errorf("invalid preprocessing: %w", err)
+	}
+	if err := m.dec.Validate(); err != nil {
+		return fmt.Errorf("invalid decoding: %w", err)
+	}
+	if err := m.engine.Load(m.modelPath); err != nil {
+		return fmt.Errorf("unable to load model: %w", err)
+	}
+
+	var err error
+	m.steeringIdx, m.throttleIdx, err = resolveOutputs(m.engine.Outputs(), m.steeringOutput, m.throttleOutput)
+	if err != nil {
+		return fmt.Errorf("unable to find model outputs: %w", err)
+	}
+	if len(m.engine.Inputs()) == 0 {
+		return fmt.Errorf("model has no input")
+	}
+	m.packer, err = newPacker(m.engine.Inputs()[0], m.pre)
+	if err != nil {
+		return err
+	}
+	m.layout, err = newStackLayout(m.engine.Inputs()[0], m.pre.Crop.Dx(), m.pre.Crop.Dy(), m.pre.ColorOrder.Channels())
+	if err != nil {
+		return err
+	}
+	if m.layout.frames > 1 {
+		zap.S().Infof("model expects %d stacked frames", m.layout.frames)
+	}
+	inputs, outputs := m.engine.Inputs(), m.engine.Outputs()
+	m.buffers.New = func() interface{} {
+		b := &buffers{
+			inputs:  [][]byte{make([]byte, inputs[0].ByteSize())},
+			outputs: engine.NewBuffers(outputs),
+			values:  make([][]float32, len(outputs)),
+			probs:   make([][]float64, len(outputs)),
+		}
+		for i, o := range outputs {
+			b.values[i] = make([]float32, o.Len())
+			b.probs[i] = make([]float64, o.Len())
+		}
+		return b
+	}
+	zap.S().Infof("steering from output %d", m.steeringIdx)
+	if m.throttleIdx >= 0 {
+		zap.S().Infof("throttle from output %d", m.throttleIdx)
+	}
+	return nil
+}
+
+// Path return model file
+func (m *Model) Path() string {
+	return m.modelPath
+}
+
+// SteeringBins return number of steering bins, 0 if model isn't categorical
+func (m *Model) SteeringBins() int {
+	if m.modelType != tools.ModelTypeCategorical {
+		return 0
+	}
+	return m.engine.Outputs()[m.steeringIdx].Len()
+}
+
+// SteeringBin return index of the steering bin matching steering value
+func (m *Model) SteeringBin(steering float32) int {
+	return tools.Bin(float64(steering), m.SteeringBins(), m.dec.SteeringOffset, m.dec.SteeringRange)
+}
+
+// Frames return number of frames expected by model, models with more than one frame need a frame history
+func (m *Model) Frames() int {
+	return m.layout.frames
+}
+
+// newFrameHistory instantiate history for frames stacked by model. History is reset when gap between 2 frames is
+// greater than maxGap
+func (m *Model) newFrameHistory(maxGap time.Duration) *frameHistory {
+	return newFrameHistory(m.layout, maxGap)
+}
+
+// FrameHistory keep last frames of a sequence for models that expect many frames, see Model.PredictSeq
+type FrameHistory struct {
+	h *frameHistory
+}
+
+// NewFrameHistory instantiate history of frames for loaded model, nil if model expects a single frame. History is
+// reset when gap between 2 frames is greater than maxGap, 0 to never reset
+func (m *Model) NewFrameHistory(maxGap time.Duration) *FrameHistory {
+	if m.Frames() <= 1 {
+		return nil
+	}
+	return &FrameHistory{h: m.newFrameHistory(maxGap)}
+}
+
+// Close release engine
+func (m *Model) Close() {
+	m.engine.Close()
+}
+
+// Prediction is the result of model inference
+type Prediction struct {
+	Steering           float32
+	SteeringConfidence float32
+	// HasThrottle is false when model has no throttle output
+	HasThrottle        bool
+	Throttle           float32
+	ThrottleConfidence float32
+}
+
+// Value return steering and its confidence for img
+func (m *Model) Value(img image.Image) (float32, float32, error) {
+	prediction, err := m.Predict(img)
+	if err != nil {
+		return 0., 0., err
+	}
+	return prediction.Steering, prediction.SteeringConfidence, nil
+}
+
+// Stages of a prediction, as reported by PredictTimed
+const (
+	StageResize = "resize"
+	StageCrop   = "crop"
+	StagePack   = "pack"
+	StageRun    = "run"
+	StageDecode = "decode"
+)
+
+// Predict run model on img
+func (m *Model) Predict(img image.Image) (Prediction, error) {
+	return m.PredictTimed(img, nil)
+}
+
+// PredictTimed run model on img and call record with time spent in each stage
+func (m *Model) PredictTimed(img image.Image, record func(stage string, d time.Duration)) (Prediction, error) {
+	return m.predict(img, nil, time.Time{}, record)
+}
+
+// PredictSeq run model on img created at date at, as part of a sequence of frames. history is needed by models that
+// expect many frames, see NewFrameHistory
+func (m *Model) PredictSeq(img image.Image, at time.Time, history *FrameHistory) (Prediction, error) {
+	return m.PredictSeqTimed(img, at, history, nil)
+}
+
+// PredictSeqTimed run PredictSeq and call record with time spent in each stage
+func (m *Model) PredictSeqTimed(img image.Image, at time.Time, history *FrameHistory, record func(stage string, d time.Duration)) (Prediction, error) {
+	var h *frameHistory
+	if history != nil {
+		h = history.h
+	}
+	return m.predict(img, h, at, record)
+}
+
+// predict run model on img. Models that expect many frames need history, img is added to history with its creation
+// date at
+func (m *Model) predict(img image.Image, history *frameHistory, at time.Time, record func(stage string, d time.Duration)) (Prediction, error) {
+	if history == nil && m.layout.frames > 1 {
+		return Prediction{}, fmt.Errorf("model expects %d frames, a frame history is needed", m.layout.frames)
+	}
+
+	start := time.Now()
+	lap := func(stage string) {
+		if record == nil {
+			return
+		}
+		now := time.Now()
+		record(stage, now.Sub(start))
+		start = now
+	}
+
+	img = m.pre.resize(img)
+	lap(StageResize)
+	region := m.pre.crop(img)
+	lap(StageCrop)
+
+	buf := m.buffers.Get().(*buffers)
+	defer m.buffers.Put(buf)
+
+	if history == nil {
+		if err := m.packer.pack(img, region, buf.inputs[0]); err != nil {
+			return Prediction{}, err
+		}
+	} else {
+		pack := func(dst []byte) error {
+			return m.packer.pack(img, region, dst)
+		}
+		if err := history.add(at, pack, buf.inputs[0]); err != nil {
+			return Prediction{}, err
+		}
+	}
+	lap(StagePack)
+
+	if err := m.engine.Run(buf.inputs, buf.outputs); err != nil {
+		return Prediction{}, err
+	}
+	lap(StageRun)
+
+	var prediction Prediction
+	steering, score, err := m.decode(buf, m.steeringIdx, m.dec.SteeringOffset, m.dec.SteeringRange)
+	if err != nil {
+		return Prediction{}, fmt.Errorf("unable to decode steering: %w", err)
+	}
+	prediction.Steering, prediction.SteeringConfidence = float32(steering), float32(score)
+	zap.L().Debug("found steering",
+		zap.Float64("steering", steering),
+		zap.Float64("score", score),
+	)
+
+	if m.throttleIdx < 0 {
+		lap(StageDecode)
+		return prediction, nil
+	}
+	throttle, score, err := m.decode(buf, m.throttleIdx, m.dec.ThrottleOffset, m.dec.ThrottleRange)
+	if err != nil {
+		return Prediction{}, fmt.Errorf("unable to decode throttle: %w", err)
+	}
+	prediction.HasThrottle = true
+	prediction.Throttle, prediction.ThrottleConfidence = float32(throttle), float32(score)
+	zap.L().Debug("found throttle",
+		zap.Float64("throttle", throttle),
+		zap.Float64("score", score),
+	)
+	lap(StageDecode)
+	return prediction, nil
+}
+
+// decode output idx. Categorical outputs are decoded as bins over range r starting at offset, one bin by value of
+// output
+func (m *Model) decode(buf *buffers, idx int, offset, r float64) (float64, float64, error) {
+	output, legacy := withDefaultQuantization(m.engine.Outputs()[idx])
+	values := buf.values[idx]
+	if err := output.Dequantize(buf.outputs[idx], values); err != nil {
+		return 0., 0., fmt.Errorf("unable to dequantize output: %w", err)
+	}
+	zap.L().Debug("raw output", zap.Int("output", idx), zap.Float32s("result", values))
+
+	var value, score float64
+	switch m.modelType {
+	case tools.ModelTypeCategorical:
+		value, score = m.dec.decode(values, buf.probs[idx], offset, r)
+	case tools.ModelTypeLinear:
+		value = float64(values[0])
+		if legacy {
+			// Without quantization parameters, output is expected to be [-1, 1] scaled to [0, 1]
+			value = 2*value - 1.
+		}
+		score = 0.6
+	}
+	return value, score, nil
+}
+
+// withDefaultQuantization return tensor with quantization that map [0, 255] to [0, 1] if integer tensor hasn't
+// quantization parameters. Second value is true when default quantization is applied
+func withDefaultQuantization(t engine.Tensor) (engine.Tensor, bool) {
+	if t.Quantized() || t.Type == engine.TensorTypeFloat32 {
+		return t, false
+	}
+	t.Quantization = engine.Quantization{Scale: 1. / 255., ZeroPoint: 0}
+	return t, true
+}
@@ -0,0 +1,76 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"strconv"
+	"strings"
+)
+
+var (
+	steeringKeywords = []string{"angle", "steering"}
+	throttleKeywords = []string{"throttle"}
+)
+
+// resolveOutputs return indexes of steering and throttle outputs. Throttle index is negative if model has no
+// throttle output
+func resolveOutputs(outputs []engine.Tensor, steeringSelector, throttleSelector string) (int, int, error) {
+	if len(outputs) == 0 {
+		return -1, -1, fmt.Errorf("model has no output")
+	}
+
+	throttleIdx, err := findOutput(outputs, throttleSelector, throttleKeywords)
+	if err != nil {
+		return -1, -1, fmt.Errorf("invalid throttle output: %w", err)
+	}
+
+	steeringIdx, err := findOutput(outputs, steeringSelector, steeringKeywords)
+	if err != nil {
+		return -1, -1, fmt.Errorf("invalid steering output: %w", err)
+	}
+	if steeringIdx < 0 {
+		// Use first output not used by throttle
+		for i := range outputs {
+			if i != throttleIdx {
+				steeringIdx = i
+				break
+			}
+		}
+	}
+	if steeringIdx < 0 {
+		return -1, -1, fmt.Errorf("no output available for steering")
+	}
+	if steeringIdx == throttleIdx {
+		return -1, -1, fmt.Errorf("output %d used for both steering and throttle", steeringIdx)
+	}
+	return steeringIdx, throttleIdx, nil
+}
+
+// findOutput return index of output matching selector, an index or a tensor name. Without selector, first output
+// which name contains one of keywords is returned, or -1 if none match
+func findOutput(outputs []engine.Tensor, selector string, keywords []string) (int, error) {
+	if selector == "" {
+		for i, o := range outputs {
+			name := strings.ToLower(o.Name)
+			for _, k := range keywords {
+				if strings.Contains(name, k) {
+					return i, nil
+				}
+			}
+		}
+		return -1, nil
+	}
+
+	if idx, err := strconv.Atoi(selector); err == nil {
+		if idx < 0 || idx >= len(outputs) {
+			return -1, fmt.Errorf("output index %d out of range, model has %d outputs", idx, len(outputs))
+		}
+		return idx, nil
+	}
+	for i, o := range outputs {
+		if o.Name == selector {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no output named '%v'", selector)
+}
@@ -0,0 +1,51 @@
+package steering
+
+import (
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"testing"
+)
+
+func Test_resolveOutputs(t *testing.T) {
+	named := []engine.Tensor{{Name: "StatefulPartitionedCall:throttle_out"}, {Name: "StatefulPartitionedCall:angle_out"}}
+	anonymous := []engine.Tensor{{Name: "StatefulPartitionedCall:0"}, {Name: "StatefulPartitionedCall:1"}}
+
+	tests := []struct {
+		name             string
+		outputs          []engine.Tensor
+		steeringSelector string
+		throttleSelector string
+		wantSteering     int
+		wantThrottle     int
+		wantErr          bool
+	}{
+		{name: "single output", outputs: anonymous[:1], wantSteering: 0, wantThrottle: -1},
+		{name: "detected by name", outputs: named, wantSteering: 1, wantThrottle: 0},
+		{name: "no throttle without name", outputs: anonymous, wantSteering: 0, wantThrottle: -1},
+		{name: "by index", outputs: anonymous, steeringSelector: "1", throttleSelector: "0", wantSteering: 1, wantThrottle: 0},
+		{name: "throttle by index only", outputs: anonymous, throttleSelector: "0", wantSteering: 1, wantThrottle: 0},
+		{name: "by name", outputs: anonymous, steeringSelector: "StatefulPartitionedCall:1", throttleSelector: "StatefulPartitionedCall:0", wantSteering: 1, wantThrottle: 0},
+		{name: "index out of range", outputs: anonymous, throttleSelector: "2", wantErr: true},
+		{name: "unknown name", outputs: anonymous, steeringSelector: "angle", wantErr: true},
+		{name: "same output", outputs: anonymous, steeringSelector: "0", throttleSelector: "0", wantErr: true},
+		{name: "single output used by throttle", outputs: anonymous[:1], throttleSelector: "0", wantErr: true},
+		{name: "no output", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSteering, gotThrottle, err := resolveOutputs(tt.outputs, tt.steeringSelector, tt.throttleSelector)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("resolveOutputs() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotSteering != tt.wantSteering {
+				t.Errorf("resolveOutputs() steering = %v, want %v", gotSteering, tt.wantSteering)
+			}
+			if gotThrottle != tt.wantThrottle {
+				t.Errorf("resolveOutputs() throttle = %v, want %v", gotThrottle, tt.wantThrottle)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"image"
+	"image/color"
+)
+
+// packer write image pixels into input tensor buffer, with channel order and normalization of preprocessing
+type packer struct {
+	tensor engine.Tensor
+	// size is the number of bytes of a tensor value
+	size int
+	// order is the order of channels, 0 for red, 1 for green and 2 for blue
+	order [3]int
+	gray  bool
+	mean  [3]float32
+	std   [3]float32
+	// lut map each 8 bits value of a model channel to its quantized bytes
+	lut [3][256][4]byte
+}
+
+func newPacker(input engine.Tensor, pre Preprocessing) (*packer, error) {
+	input, _ = withDefaultQuantization(input)
+	p := &packer{
+		tensor: input,
+		size:   input.Type.Size(),
+		order:  [3]int{0, 1, 2},
+		gray:   pre.ColorOrder == ColorOrderGray,
+		mean:   pre.Mean,
+		std:    pre.Std,
+	}
+	if p.size <= 0 || p.size > len(p.lut[0][0]) {
+		return nil, fmt.Errorf("unsupported type %v for input tensor %v", input.Type, input.Name)
+	}
+	if pre.ColorOrder == ColorOrderBGR {
+		p.order = [3]int{2, 1, 0}
+	}
+
+	scalar := engine.Tensor{Name: input.Name, Type: input.Type, Shape: []int{1}, Quantization: input.Quantization}
+	for c := range p.lut {
+		for v := range p.lut[c] {
+			if err := scalar.Quantize([]float32{p.normalize(c, float32(v)/0xff)}, p.lut[c][v][:p.size]); err != nil {
+				return nil, fmt.Errorf("unable to quantize input: %w", err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// normalize value v, in [0, 1], of model channel c
+func (p *packer) normalize(c int, v float32) float32 {
+	return (v - p.mean[c]) / p.std[c]
+}
+
+func (p *packer) channels() int {
+	if p.gray {
+		return 1
+	}
+	return 3
+}
+
+// pack write pixels of region r of img, as a single frame, into dst. *image.YCbCr, *image.NRGBA and *image.RGBA are
+// read from their pixel buffers without allocation
+func (p *packer) pack(img image.Image, r image.Rectangle, dst []byte) error {
+	if want := r.Dx() * r.Dy() * p.channels() * p.size; len(dst) != want {
+		return fmt.Errorf("image region %v doesn't match input tensor %v, expected %d bytes, got %d", r, p.tensor, want, len(dst))
+	}
+
+	switch img := img.(type) {
+	case *image.YCbCr:
+		p.packYCbCr(img, r, dst)
+	case *image.NRGBA:
+		p.packNRGBA(img, r, dst)
+	case *image.RGBA:
+		p.packRGBA(img, r, dst)
+	default:
+		return p.packGeneric(img, r, dst)
+	}
+	return nil
+}
+
+// putPixel write quantized values of pixel at offset o of dst, return offset of next pixel
+func (p *packer) putPixel(dst []byte, o int, r, g, b uint8) int {
+	if p.gray {
+		return p.put(dst, o, 0, grayLevel(r, g, b))
+	}
+	rgb := [3]uint8{r, g, b}
+	o = p.put(dst, o, 0, rgb[p.order[0]])
+	o = p.put(dst, o, 1, rgb[p.order[1]])
+	return p.put(dst, o, 2, rgb[p.order[2]])
+}
+
+// put write quantized value v of model channel c at offset o of dst, return offset of next value
+func (p *packer) put(dst []byte, o int, c int, v uint8) int {
+	if p.size == 1 {
+		dst[o] = p.lut[c][v][0]
+		return o + 1
+	}
+	copy(dst[o:o+p.size], p.lut[c][v][:p.size])
+	return o + p.size
+}
+
+// grayLevel use same coefficients as color.GrayModel
+func grayLevel(r, g, b uint8) uint8 {
+	return uint8((19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16)
+}
+
+func (p *packer) packYCbCr(img *image.YCbCr, r image.Rectangle, dst []byte) {
+	o := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			yi := img.YOffset(x, y)
+			ci := img.COffset(x, y)
+			cr, cg, cb := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			o = p.putPixel(dst, o, cr, cg, cb)
+		}
+	}
+}
+
+func (p *packer) packNRGBA(img *image.NRGBA, r image.Rectangle, dst []byte) {
+	o := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pix := img.Pix[img.PixOffset(r.Min.X, y):]
+		for i := 0; i < r.Dx()*4; i += 4 {
+			cr, cg, cb, ca := pix[i], pix[i+1], pix[i+2], pix[i+3]
+			if ca != 0xff {
+				// Like color.NRGBA.RGBA, pixels are premultiplied by alpha
+				cr = premultiply(cr, ca)
+				cg = premultiply(cg, ca)
+				cb = premultiply(cb, ca)
+			}
+			o = p.putPixel(dst, o, cr, cg, cb)
+		}
+	}
+}
+
+func premultiply(c, a uint8) uint8 {
+	return uint8((uint32(c)*uint32(a) + 0x7f) / 0xff)
+}
+
+func (p *packer) packRGBA(img *image.RGBA, r image.Rectangle, dst []byte) {
+	o := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pix := img.Pix[img.PixOffset(r.Min.X, y):]
+		for i := 0; i < r.Dx()*4; i += 4 {
+			o = p.putPixel(dst, o, pix[i], pix[i+1], pix[i+2])
+		}
+	}
+}
+
+// packGeneric read pixels with image.Image interface, slow but support any image type
+func (p *packer) packGeneric(img image.Image, r image.Rectangle, dst []byte) error {
+	dx := r.Dx()
+	channels := p.channels()
+	pixels := make([]float32, dx*r.Dy()*channels)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			i := ((y-r.Min.Y)*dx + x - r.Min.X) * channels
+			if p.gray {
+				gray := (19595*cr + 38470*cg + 7471*cb + 1<<15) >> 16
+				pixels[i] = p.normalize(0, float32(gray)/0xffff)
+				continue
+			}
+			rgb := [3]uint32{cr, cg, cb}
+			for c := 0; c < 3; c++ {
+				pixels[i+c] = p.normalize(c, float32(rgb[p.order[c]])/0xffff)
+			}
+		}
+	}
+	frame := p.tensor
+	frame.Shape = []int{len(pixels)}
+	if err := frame.Quantize(pixels, dst); err != nil {
+		return fmt.Errorf("unable to quantize input: %w", err)
+	}
+	return nil
+}
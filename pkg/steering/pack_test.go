@@ -0,0 +1,198 @@
+package steering
+
+import (
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// testImages return images of each type supported by packer fast paths, with same random content
+func testImages(width, height int) map[string]image.Image {
+	rnd := rand.New(rand.NewSource(1))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, width, height), image.YCbCrSubsampleRatio420)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.NRGBA{R: uint8(rnd.Intn(256)), G: uint8(rnd.Intn(256)), B: uint8(rnd.Intn(256)), A: 0xff}
+			nrgba.SetNRGBA(x, y, c)
+			rgba.Set(x, y, c)
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			ycbcr.Y[ycbcr.YOffset(x, y)] = yy
+			ycbcr.Cb[ycbcr.COffset(x, y)] = cb
+			ycbcr.Cr[ycbcr.COffset(x, y)] = cr
+		}
+	}
+	return map[string]image.Image{"nrgba": nrgba, "rgba": rgba, "ycbcr": ycbcr}
+}
+
+func packTensor(t engine.TensorType, width, height int) engine.Tensor {
+	tensor := engine.Tensor{Name: "input", Type: t, Shape: []int{1, height, width, 3}}
+	if t != engine.TensorTypeFloat32 {
+		tensor.Quantization = engine.Quantization{Scale: 1. / 255.}
+	}
+	return tensor
+}
+
+func TestPacker_pack(t *testing.T) {
+	const width, height, horizon = 32, 24, 5
+	region := image.Rect(0, horizon, width, height)
+
+	for _, tensorType := range []engine.TensorType{engine.TensorTypeUInt8, engine.TensorTypeFloat32} {
+		tensor := packTensor(tensorType, width, height-horizon)
+		p, err := newPacker(tensor, DefaultPreprocessing(width, height, horizon))
+		if err != nil {
+			t.Fatalf("newPacker() error = %v", err)
+		}
+		for name, img := range testImages(width, height) {
+			t.Run(tensorType.String()+"/"+name, func(t *testing.T) {
+				want := make([]byte, tensor.ByteSize())
+				if err := p.packGeneric(img, region, want); err != nil {
+					t.Fatalf("packGeneric() error = %v", err)
+				}
+				got := make([]byte, tensor.ByteSize())
+				if err := p.pack(img, region, got); err != nil {
+					t.Fatalf("pack() error = %v", err)
+				}
+
+				wantValues := make([]float32, tensor.Len())
+				gotValues := make([]float32, tensor.Len())
+				_ = tensor.Dequantize(want, wantValues)
+				_ = tensor.Dequantize(got, gotValues)
+				for i := range wantValues {
+					// YCbCr conversion to 8 bits RGB may differ from 16 bits conversion by one level
+					if diff := gotValues[i] - wantValues[i]; diff > 1.01/255 || diff < -1.01/255 {
+						t.Fatalf("pack() value %d = %v, want %v", i, gotValues[i], wantValues[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestPacker_pack_subImage(t *testing.T) {
+	const width, height = 32, 24
+	tensor := packTensor(engine.TensorTypeUInt8, 10, 8)
+	p, err := newPacker(tensor, DefaultPreprocessing(width, height, 0))
+	if err != nil {
+		t.Fatalf("newPacker() error = %v", err)
+	}
+
+	img := testImages(width, height)["rgba"].(*image.RGBA)
+	sub := img.SubImage(image.Rect(5, 6, 15, 14))
+	got := make([]byte, tensor.ByteSize())
+	if err := p.pack(sub, sub.Bounds(), got); err != nil {
+		t.Fatalf("pack() error = %v", err)
+	}
+	c := img.RGBAAt(5, 6)
+	if got[0] != c.R || got[1] != c.G || got[2] != c.B {
+		t.Errorf("pack() first pixel = %v, want %v", got[:3], c)
+	}
+	c = img.RGBAAt(14, 13)
+	if last := got[len(got)-3:]; last[0] != c.R || last[1] != c.G || last[2] != c.B {
+		t.Errorf("pack() last pixel = %v, want %v", last, c)
+	}
+
+	if err := p.pack(img, img.Bounds(), got); err == nil {
+		t.Errorf("pack() with region bigger than tensor, want error")
+	}
+}
+
+func TestPacker_pack_allocs(t *testing.T) {
+	const width, height = 160, 120
+	tensor := packTensor(engine.TensorTypeUInt8, width, height)
+	p, err := newPacker(tensor, DefaultPreprocessing(width, height, 0))
+	if err != nil {
+		t.Fatalf("newPacker() error = %v", err)
+	}
+	dst := make([]byte, tensor.ByteSize())
+	for name, img := range testImages(width, height) {
+		allocs := testing.AllocsPerRun(10, func() {
+			_ = p.pack(img, img.Bounds(), dst)
+		})
+		if allocs != 0 {
+			t.Errorf("pack() of %v image allocate %v times, want 0", name, allocs)
+		}
+	}
+}
+
+func BenchmarkPacker_pack(b *testing.B) {
+	const width, height, horizon = 160, 120, 20
+	region := image.Rect(0, horizon, width, height)
+	tensor := packTensor(engine.TensorTypeUInt8, width, height-horizon)
+	p, err := newPacker(tensor, DefaultPreprocessing(width, height, horizon))
+	if err != nil {
+		b.Fatalf("newPacker() error = %v", err)
+	}
+	dst := make([]byte, tensor.ByteSize())
+
+	for name, img := range testImages(width, height) {
+		b.Run("generic/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = p.packGeneric(img, region, dst)
+			}
+		})
+		b.Run("fast/"+name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_ = p.pack(img, region, dst)
+			}
+		})
+	}
+}
+
+func TestPacker_pack_preprocessing(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{R: 255, G: 102, B: 0, A: 255})
+
+	tests := []struct {
+		name     string
+		settings map[string]string
+		channels int
+		want     []float32
+	}{
+		{name: "rgb", channels: 3, want: []float32{1., 0.4, 0.}},
+		{name: "bgr", settings: map[string]string{PreprocessingColorOrder: "bgr"}, channels: 3, want: []float32{0., 0.4, 1.}},
+		{name: "gray", settings: map[string]string{PreprocessingColorOrder: "gray"}, channels: 1, want: []float32{0.533}},
+		{
+			name:     "mean and std",
+			settings: map[string]string{PreprocessingMean: "0.5", PreprocessingStd: "0.5,0.2,0.5"},
+			channels: 3,
+			want:     []float32{1., -0.5, -1.},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pre := DefaultPreprocessing(1, 1, 0)
+			for k, v := range tt.settings {
+				if err := pre.Set(k, v); err != nil {
+					t.Fatalf("Set(%v, %v) error = %v", k, v, err)
+				}
+			}
+			tensor := engine.Tensor{Name: "input", Type: engine.TensorTypeFloat32, Shape: []int{1, 1, 1, tt.channels}}
+			p, err := newPacker(tensor, pre)
+			if err != nil {
+				t.Fatalf("newPacker() error = %v", err)
+			}
+
+			for name, pack := range map[string]func(image.Image, image.Rectangle, []byte) error{"fast": p.pack, "generic": p.packGeneric} {
+				b := make([]byte, tensor.ByteSize())
+				if err := pack(img, img.Bounds(), b); err != nil {
+					t.Fatalf("%v pack() error = %v", name, err)
+				}
+				got := make([]float32, tensor.Len())
+				_ = tensor.Dequantize(b, got)
+				for i := range tt.want {
+					if math.Abs(float64(got[i]-tt.want[i])) > 0.005 {
+						t.Errorf("%v pack() = %v, want %v", name, got, tt.want)
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package steering
+
+import (
+	"context"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	dropReasonReplaced = "replaced"
+	dropReasonTooOld   = "too_old"
+)
+
+// frameSlot keep only the latest frame not yet processed
+type frameSlot struct {
+	mu    sync.Mutex
+	frame *events.FrameMessage
+	ready chan struct{}
+}
+
+func newFrameSlot() *frameSlot {
+	return &frameSlot{ready: make(chan struct{}, 1)}
+}
+
+// put store frame in slot, return the pending frame replaced or nil
+func (s *frameSlot) put(frame *events.FrameMessage) *events.FrameMessage {
+	s.mu.Lock()
+	replaced := s.frame
+	s.frame = frame
+	s.mu.Unlock()
+
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return replaced
+}
+
+// take wait for a pending frame. Return false if cancel is closed before
+func (s *frameSlot) take(cancel <-chan interface{}) (*events.FrameMessage, bool) {
+	for {
+		select {
+		case <-cancel:
+			return nil, false
+		case <-s.ready:
+		}
+
+		s.mu.Lock()
+		frame := s.frame
+		s.frame = nil
+		s.mu.Unlock()
+		if frame != nil {
+			return frame, true
+		}
+		// Frame already taken by another worker
+	}
+}
+
+func dropFrame(frame *events.FrameMessage, reason string) {
+	zap.L().Debug("drop frame",
+		zap.String("frame", frame.GetId().GetId()),
+		zap.String("reason", reason),
+	)
+	go metrics.DroppedFrames.Add(context.Background(), 1, attribute.String("reason", reason))
+}
+
+// runWorker process latest frames until cancel is closed
+func (p *Part) runWorker(cancel <-chan interface{}) {
+	for {
+		frame, ok := p.frames.take(cancel)
+		if !ok {
+			return
+		}
+		if p.maxFrameAge > 0 && time.Since(frame.GetId().GetCreatedAt().AsTime()) > p.maxFrameAge {
+			dropFrame(frame, dropReasonTooOld)
+			continue
+		}
+		p.processFrame(frame)
+	}
+}
@@ -0,0 +1,96 @@
+package steering
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_frameSlot(t *testing.T) {
+	s := newFrameSlot()
+	first := &events.FrameMessage{Id: &events.FrameRef{Id: "1"}}
+	second := &events.FrameMessage{Id: &events.FrameRef{Id: "2"}}
+
+	if replaced := s.put(first); replaced != nil {
+		t.Errorf("put() on empty slot replaced %v", replaced)
+	}
+	if replaced := s.put(second); replaced != first {
+		t.Errorf("put() replaced %v, want %v", replaced, first)
+	}
+
+	cancel := make(chan interface{})
+	frame, ok := s.take(cancel)
+	if !ok || frame != second {
+		t.Errorf("take() = %v, %v, want latest frame %v", frame, ok, second)
+	}
+
+	close(cancel)
+	if frame, ok := s.take(cancel); ok {
+		t.Errorf("take() on cancelled empty slot = %v", frame)
+	}
+}
+
+func TestPart_runWorker(t *testing.T) {
+	msgs := recordPublish(t)
+
+	jpg, err := os.ReadFile("test_data/image.jpg")
+	if err != nil {
+		t.Fatalf("unable to read test image: %v", err)
+	}
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(7)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithMaxFrameAge(500*time.Millisecond))
+
+	p.frames.put(&events.FrameMessage{
+		Id:    &events.FrameRef{Id: "old", CreatedAt: timestamppb.New(time.Now().Add(-time.Second))},
+		Frame: jpg,
+	})
+
+	cancel := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		p.runWorker(cancel)
+		close(done)
+	}()
+
+	// Wait old frame is dropped before sending a fresh one
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.frames.mu.Lock()
+		pending := p.frames.frame
+		p.frames.mu.Unlock()
+		if pending == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.frames.put(&events.FrameMessage{
+		Id:    &events.FrameRef{Id: "fresh", CreatedAt: timestamppb.Now()},
+		Frame: jpg,
+	})
+
+	for len(eng.Calls()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(cancel)
+	<-done
+
+	if len(eng.Calls()) != 1 {
+		t.Fatalf("runWorker() invoked model %d times, want 1", len(eng.Calls()))
+	}
+	if len(*msgs) != 1 {
+		t.Fatalf("runWorker() published %d messages, want 1", len(*msgs))
+	}
+	var steeringMsg events.SteeringMessage
+	if err := proto.Unmarshal((*msgs)[0].payload, &steeringMsg); err != nil {
+		t.Fatalf("unable to unmarshal steering message: %v", err)
+	}
+	if steeringMsg.GetFrameRef().GetId() != "fresh" {
+		t.Errorf("runWorker() published result for frame %v, want %v", steeringMsg.GetFrameRef().GetId(), "fresh")
+	}
+}
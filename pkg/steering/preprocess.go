@@ -0,0 +1,224 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/disintegration/imaging"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// Preprocessing settings names, as used by Preprocessing.Set and oci annotations
+const (
+	PreprocessingResizeFilter = "resize_filter"
+	PreprocessingCrop         = "crop"
+	PreprocessingColorOrder   = "color_order"
+	PreprocessingMean         = "mean"
+	PreprocessingStd          = "std"
+)
+
+// PreprocessingSettings list settings accepted by Preprocessing.Set
+var PreprocessingSettings = []string{
+	PreprocessingResizeFilter,
+	PreprocessingCrop,
+	PreprocessingColorOrder,
+	PreprocessingMean,
+	PreprocessingStd,
+}
+
+var resizeFilters = map[string]imaging.ResampleFilter{
+	"nearest":  imaging.NearestNeighbor,
+	"box":      imaging.Box,
+	"bilinear": imaging.Linear,
+	"bicubic":  imaging.CatmullRom,
+	"lanczos":  imaging.Lanczos,
+}
+
+type ColorOrder int
+
+const (
+	ColorOrderRGB ColorOrder = iota
+	ColorOrderBGR
+	ColorOrderGray
+)
+
+func ParseColorOrder(s string) (ColorOrder, error) {
+	switch strings.ToLower(s) {
+	case "rgb":
+		return ColorOrderRGB, nil
+	case "bgr":
+		return ColorOrderBGR, nil
+	case "gray", "grayscale":
+		return ColorOrderGray, nil
+	default:
+		return ColorOrderRGB, fmt.Errorf("unknown color order '%v'", s)
+	}
+}
+
+func (c ColorOrder) String() string {
+	switch c {
+	case ColorOrderRGB:
+		return "rgb"
+	case ColorOrderBGR:
+		return "bgr"
+	case ColorOrderGray:
+		return "gray"
+	default:
+		return "unknown"
+	}
+}
+
+// Channels return number of values by pixel
+func (c ColorOrder) Channels() int {
+	if c == ColorOrderGray {
+		return 1
+	}
+	return 3
+}
+
+// Preprocessing describe transformations applied to images before inference: images are resized to Width x Height,
+// cropped to Crop box, then each channel value v, in [0, 1], is normalized to (v - Mean) / Std
+type Preprocessing struct {
+	Width, Height int
+	ResizeFilter  string
+	// Crop is the region of resized image sent to model
+	Crop       image.Rectangle
+	ColorOrder ColorOrder
+	// Mean and Std are indexed by model channel. Grayscale images only use first value
+	Mean [3]float32
+	Std  [3]float32
+}
+
+// DefaultPreprocessing resize images with nearest neighbor filter and crop horizon rows at the top. Values are in
+// [0, 1], RGB order
+func DefaultPreprocessing(width, height, horizon int) Preprocessing {
+	return Preprocessing{
+		Width:        width,
+		Height:       height,
+		ResizeFilter: "nearest",
+		Crop:         image.Rect(0, horizon, width, height),
+		ColorOrder:   ColorOrderRGB,
+		Std:          [3]float32{1., 1., 1.},
+	}
+}
+
+// Set update setting from its text value:
+//   - resize_filter: nearest, box, bilinear, bicubic or lanczos
+//   - crop: box 'x0,y0,x1,y1' in resized image
+//   - color_order: rgb, bgr or gray
+//   - mean and std: one value for all channels or one value by channel, comma separated
+func (p *Preprocessing) Set(name, value string) error {
+	switch name {
+	case PreprocessingResizeFilter:
+		if _, ok := resizeFilters[value]; !ok {
+			return fmt.Errorf("unknown resize filter '%v'", value)
+		}
+		p.ResizeFilter = value
+	case PreprocessingCrop:
+		v, err := parseInts(value, 4)
+		if err != nil {
+			return fmt.Errorf("bad crop box '%v': %w", value, err)
+		}
+		p.Crop = image.Rect(v[0], v[1], v[2], v[3])
+	case PreprocessingColorOrder:
+		c, err := ParseColorOrder(value)
+		if err != nil {
+			return err
+		}
+		p.ColorOrder = c
+	case PreprocessingMean:
+		v, err := parseChannels(value)
+		if err != nil {
+			return fmt.Errorf("bad mean '%v': %w", value, err)
+		}
+		p.Mean = v
+	case PreprocessingStd:
+		v, err := parseChannels(value)
+		if err != nil {
+			return fmt.Errorf("bad std '%v': %w", value, err)
+		}
+		for _, s := range v {
+			if s == 0 {
+				return fmt.Errorf("bad std '%v': value can't be 0", value)
+			}
+		}
+		p.Std = v
+	default:
+		return fmt.Errorf("unknown preprocessing setting '%v'", name)
+	}
+	return nil
+}
+
+// Validate check preprocessing consistency
+func (p *Preprocessing) Validate() error {
+	if p.Width <= 0 || p.Height <= 0 {
+		return fmt.Errorf("invalid resize %dx%d", p.Width, p.Height)
+	}
+	if _, ok := resizeFilters[p.ResizeFilter]; !ok {
+		return fmt.Errorf("unknown resize filter '%v'", p.ResizeFilter)
+	}
+	if p.Crop.Empty() || !p.Crop.In(image.Rect(0, 0, p.Width, p.Height)) {
+		return fmt.Errorf("crop box %v is outside of resized image %dx%d", p.Crop, p.Width, p.Height)
+	}
+	for _, s := range p.Std {
+		if s == 0 {
+			return fmt.Errorf("std can't be 0")
+		}
+	}
+	return nil
+}
+
+func (p *Preprocessing) String() string {
+	return fmt.Sprintf("resize %dx%d (%v), crop %v, %v, mean %v, std %v",
+		p.Width, p.Height, p.ResizeFilter, p.Crop, p.ColorOrder, p.Mean, p.Std)
+}
+
+// resize img to Width x Height if needed
+func (p *Preprocessing) resize(img image.Image) image.Image {
+	if img.Bounds().Dx() == p.Width && img.Bounds().Dy() == p.Height {
+		return img
+	}
+	return imaging.Resize(img, p.Width, p.Height, resizeFilters[p.ResizeFilter])
+}
+
+// crop return region of resized img sent to model. Pixels aren't copied, region is read by packer
+func (p *Preprocessing) crop(img image.Image) image.Rectangle {
+	return p.Crop.Add(img.Bounds().Min)
+}
+
+func parseInts(value string, n int) ([]int, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("expected %d values, got %d", n, len(fields))
+	}
+	result := make([]int, n)
+	for i, f := range fields {
+		v, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		result[i] = v
+	}
+	return result, nil
+}
+
+// parseChannels parse one value for all channels or one value by channel
+func parseChannels(value string) ([3]float32, error) {
+	var result [3]float32
+	fields := strings.Split(value, ",")
+	if len(fields) != 1 && len(fields) != 3 {
+		return result, fmt.Errorf("expected 1 or 3 values, got %d", len(fields))
+	}
+	for i := range result {
+		f := fields[0]
+		if len(fields) == 3 {
+			f = fields[i]
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 32)
+		if err != nil {
+			return result, err
+		}
+		result[i] = float32(v)
+	}
+	return result, nil
+}
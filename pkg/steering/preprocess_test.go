@@ -0,0 +1,116 @@
+package steering
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestPreprocessing_Set(t *testing.T) {
+	tests := []struct {
+		name    string
+		setting string
+		value   string
+		check   func(p Preprocessing) bool
+		wantErr bool
+	}{
+		{name: "resize filter", setting: PreprocessingResizeFilter, value: "bilinear", check: func(p Preprocessing) bool { return p.ResizeFilter == "bilinear" }},
+		{name: "unknown resize filter", setting: PreprocessingResizeFilter, value: "magic", wantErr: true},
+		{name: "crop", setting: PreprocessingCrop, value: "10, 20,150,100", check: func(p Preprocessing) bool { return p.Crop == image.Rect(10, 20, 150, 100) }},
+		{name: "bad crop", setting: PreprocessingCrop, value: "10,20,150", wantErr: true},
+		{name: "color order", setting: PreprocessingColorOrder, value: "BGR", check: func(p Preprocessing) bool { return p.ColorOrder == ColorOrderBGR }},
+		{name: "unknown color order", setting: PreprocessingColorOrder, value: "cmyk", wantErr: true},
+		{name: "mean for all channels", setting: PreprocessingMean, value: "0.5", check: func(p Preprocessing) bool { return p.Mean == [3]float32{0.5, 0.5, 0.5} }},
+		{name: "mean by channel", setting: PreprocessingMean, value: "0.485,0.456,0.406", check: func(p Preprocessing) bool { return p.Mean == [3]float32{0.485, 0.456, 0.406} }},
+		{name: "bad mean", setting: PreprocessingMean, value: "0.5,0.5", wantErr: true},
+		{name: "null std", setting: PreprocessingStd, value: "0", wantErr: true},
+		{name: "unknown setting", setting: "rotate", value: "90", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultPreprocessing(160, 120, 20)
+			err := p.Set(tt.setting, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(p) {
+				t.Errorf("Set() result = %v", p.String())
+			}
+		})
+	}
+}
+
+func TestPreprocessing_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		crop    image.Rectangle
+		wantErr bool
+	}{
+		{name: "default", crop: image.Rect(0, 20, 160, 120)},
+		{name: "outside", crop: image.Rect(0, 20, 161, 120), wantErr: true},
+		{name: "empty", crop: image.Rect(0, 120, 160, 120), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := DefaultPreprocessing(160, 120, 20)
+			p.Crop = tt.crop
+			if err := p.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreprocessing_resize(t *testing.T) {
+	// Stripes of 1 pixel, nearest neighbor keep one color, bilinear mix them
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.NRGBA{A: 255}
+			if x%2 == 1 {
+				c = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+
+	tests := []struct {
+		filter   string
+		wantGray bool
+	}{
+		{filter: "nearest", wantGray: false},
+		{filter: "bilinear", wantGray: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			p := DefaultPreprocessing(2, 2, 0)
+			if err := p.Set(PreprocessingResizeFilter, tt.filter); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			img := p.resize(src)
+			if img.Bounds() != image.Rect(0, 0, 2, 2) {
+				t.Fatalf("resize() bounds = %v", img.Bounds())
+			}
+			r, _, _, _ := img.At(0, 0).RGBA()
+			gray := r > 0x1000 && r < 0xf000
+			if gray != tt.wantGray {
+				t.Errorf("resize() pixel = %v, want gray %v", r>>8, tt.wantGray)
+			}
+		})
+	}
+
+	p := DefaultPreprocessing(4, 4, 0)
+	if img := p.resize(src); img != image.Image(src) {
+		t.Errorf("resize() of image with expected size, want same image")
+	}
+}
+
+func TestPreprocessing_crop(t *testing.T) {
+	p := DefaultPreprocessing(160, 120, 0)
+	p.Crop = image.Rect(10, 20, 150, 100)
+
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200)).SubImage(image.Rect(40, 50, 200, 170))
+	if got, want := p.crop(img), image.Rect(50, 70, 190, 150); got != want {
+		t.Errorf("crop() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package steering
+
+import (
+	"context"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"strings"
+)
+
+const (
+	// RegistryWatcherPause and RegistryWatcherResume are payloads expected on registry watcher topic
+	RegistryWatcherPause  = "pause"
+	RegistryWatcherResume = "resume"
+)
+
+// RegistryWatcher poll a model registry for new models, see oci.Watcher
+type RegistryWatcher interface {
+	// Run poll registry until ctx is done
+	Run(ctx context.Context)
+	Pause()
+	Resume()
+}
+
+// WithRegistryWatcher run watcher while part is started. Watcher is paused by RegistryWatcherPause messages on topic,
+// and resumed by RegistryWatcherResume ones, if topic isn't empty
+func WithRegistryWatcher(watcher RegistryWatcher, topic string) Option {
+	return func(p *Part) {
+		p.registryWatcher = watcher
+		p.registryWatcherTopic = topic
+	}
+}
+
+// runRegistryWatcher run registry watcher until cancel is closed
+func (p *Part) runRegistryWatcher(cancel <-chan interface{}) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	go func() {
+		select {
+		case <-cancel:
+			stop()
+		case <-ctx.Done():
+		}
+	}()
+	p.registryWatcher.Run(ctx)
+}
+
+func (p *Part) onRegistryWatcher(_ mqtt.Client, message mqtt.Message) {
+	switch strings.ToLower(strings.TrimSpace(string(message.Payload()))) {
+	case RegistryWatcherPause:
+		zap.S().Info("registry watcher paused")
+		p.registryWatcher.Pause()
+	case RegistryWatcherResume:
+		zap.S().Info("registry watcher resumed")
+		p.registryWatcher.Resume()
+	default:
+		zap.S().Warnf("unexpected registry watcher command '%s', expected '%v' or '%v'", message.Payload(), RegistryWatcherPause, RegistryWatcherResume)
+	}
+}
@@ -0,0 +1,38 @@
+package steering
+
+import (
+	"context"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"testing"
+)
+
+type fakeRegistryWatcher struct {
+	paused bool
+}
+
+func (f *fakeRegistryWatcher) Run(ctx context.Context) { <-ctx.Done() }
+func (f *fakeRegistryWatcher) Pause()                  { f.paused = true }
+func (f *fakeRegistryWatcher) Resume()                 { f.paused = false }
+
+func TestPart_onRegistryWatcher(t *testing.T) {
+	watcher := fakeRegistryWatcher{}
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(0)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithRegistryWatcher(&watcher, "oci_watch"))
+
+	tests := []struct {
+		payload    string
+		wantPaused bool
+	}{
+		{payload: "pause", wantPaused: true},
+		{payload: "unknown", wantPaused: true},
+		{payload: "Resume\n", wantPaused: false},
+	}
+	for _, tt := range tests {
+		p.onRegistryWatcher(nil, &fakeMessage{topic: "oci_watch", payload: []byte(tt.payload)})
+		if watcher.paused != tt.wantPaused {
+			t.Errorf("after '%v' message, paused = %v, want %v", tt.payload, watcher.paused, tt.wantPaused)
+		}
+	}
+}
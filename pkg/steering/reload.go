@@ -0,0 +1,167 @@
+package steering
+
+import (
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"go.uber.org/zap"
+	"image"
+	"math"
+	"os"
+	"time"
+)
+
+// Loader instantiate a new model, not loaded yet, to replace current model
+type Loader func() (*Model, error)
+
+// WithReload allow to replace model at runtime by models built by loader, see Part.Reload. Reload is also triggered
+// by any message on topic if not empty, and by changes of model file, checked every watchInterval if greater than 0
+func WithReload(loader Loader, topic string, watchInterval time.Duration) Option {
+	return func(p *Part) {
+		p.loader = loader
+		p.reloadTopic = topic
+		p.watchInterval = watchInterval
+	}
+}
+
+// Reload load a new model in background and switch to it once it succeeds a warm-up inference. Current model is kept
+// when new model fails to load or to warm up
+func (p *Part) Reload() error {
+	if p.loader == nil {
+		return fmt.Errorf("model reload isn't configured")
+	}
+	return p.ReloadWith(p.loader)
+}
+
+// ReloadWith switch to model built by loader, as Reload
+func (p *Part) ReloadWith(loader Loader) error {
+	if p.ensemble != nil {
+		return fmt.Errorf("model reload isn't supported by ensemble")
+	}
+	p.muReload.Lock()
+	defer p.muReload.Unlock()
+	select {
+	case <-p.cancel:
+		return fmt.Errorf("part is stopped")
+	default:
+	}
+
+	start := time.Now()
+	m, err := loader()
+	if err != nil {
+		return fmt.Errorf("unable to init new model: %w", err)
+	}
+	if err := m.Load(); err != nil {
+		m.Close()
+		return fmt.Errorf("unable to load new model %v: %w", m.Path(), err)
+	}
+	if m.Frames() > 1 && p.workers > 1 {
+		m.Close()
+		return fmt.Errorf("new model %v expects %d frames, frames can't be stacked in order by %d workers", m.Path(), m.Frames(), p.workers)
+	}
+	if err := warmUp(m); err != nil {
+		m.Close()
+		return fmt.Errorf("warm-up of new model %v failed, keep current model: %w", m.Path(), err)
+	}
+
+	p.muModel.Lock()
+	old := p.model
+	p.model = m
+	p.history = nil
+	p.initHistory()
+	p.muModel.Unlock()
+
+	// Smoothed values come from previous model
+	p.resetFilter()
+	old.Close()
+	zap.S().Infow("model reloaded",
+		"from", old.Path(),
+		"to", m.Path(),
+		"duration", time.Since(start),
+	)
+	return nil
+}
+
+// warmUp run a first inference on a black frame so that model is validated, and ready, before it is used
+func warmUp(m *Model) error {
+	img := image.NewRGBA(image.Rect(0, 0, m.pre.Width, m.pre.Height))
+	var history *frameHistory
+	if m.Frames() > 1 {
+		history = m.newFrameHistory(0)
+	}
+	prediction, err := m.predict(img, history, time.Now(), nil)
+	if err != nil {
+		return err
+	}
+	if invalid(prediction.Steering) || (prediction.HasThrottle && invalid(prediction.Throttle)) {
+		return fmt.Errorf("invalid prediction %+v", prediction)
+	}
+	return nil
+}
+
+func invalid(v float32) bool {
+	return math.IsNaN(float64(v)) || math.IsInf(float64(v), 0)
+}
+
+func (p *Part) onReload(_ mqtt.Client, _ mqtt.Message) {
+	zap.S().Infof("model reload requested on topic %v", p.reloadTopic)
+	// Don't block mqtt client while model is loaded
+	go p.reloadOrLog()
+}
+
+func (p *Part) reloadOrLog() {
+	if err := p.Reload(); err != nil {
+		zap.S().Errorw("unable to reload model", "error", err)
+	}
+}
+
+// modelPath return path of current model
+func (p *Part) modelPath() string {
+	p.muModel.RLock()
+	defer p.muModel.RUnlock()
+	return p.model.Path()
+}
+
+// runModelWatcher reload model when its file changes, until cancel is closed. Reload waits for file to be unchanged
+// during a whole interval, so that partially written files are ignored
+func (p *Part) runModelWatcher(cancel <-chan interface{}) {
+	ticker := time.NewTicker(p.watchInterval)
+	defer ticker.Stop()
+
+	stat := func() os.FileInfo {
+		fi, err := os.Stat(p.modelPath())
+		if err != nil {
+			zap.S().Debugf("unable to stat model file: %v", err)
+			return nil
+		}
+		return fi
+	}
+	same := func(a, b os.FileInfo) bool {
+		if a == nil || b == nil {
+			return a == b
+		}
+		return a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+	}
+
+	current := stat()
+	var pending os.FileInfo
+	for {
+		select {
+		case <-cancel:
+			return
+		case <-ticker.C:
+			fi := stat()
+			if fi == nil || same(fi, current) {
+				pending = nil
+				continue
+			}
+			if !same(fi, pending) {
+				// File is changing, wait next tick
+				pending = fi
+				continue
+			}
+			zap.S().Infof("model file %v changed", p.modelPath())
+			p.reloadOrLog()
+			current, pending = stat(), nil
+		}
+	}
+}
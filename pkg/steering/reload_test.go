@@ -0,0 +1,122 @@
+package steering
+
+import (
+	"fmt"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPart_Reload(t *testing.T) {
+	failing := func(_ int, _ [][]byte) ([][]byte, error) {
+		return nil, fmt.Errorf("inference failure")
+	}
+	tests := []struct {
+		name         string
+		script       fake.Script
+		loadErr      error
+		wantErr      bool
+		wantSteering float32
+	}{
+		{name: "switch to new model", script: fake.Fixed(bins(14)), wantSteering: 1.},
+		{name: "rollback on load failure", script: fake.Fixed(bins(14)), loadErr: fmt.Errorf("corrupted model"), wantErr: true, wantSteering: -1.},
+		{name: "rollback on warm-up failure", script: failing, wantErr: true, wantSteering: -1.},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newEngine := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, tt.script)
+			newEngine.LoadErr = tt.loadErr
+			loader := func() (*Model, error) {
+				return NewModel(newEngine, tools.ModelTypeCategorical, "new.tflite", DefaultPreprocessing(imgWidth, imgHeight, horizon), DefaultDecoding(), "", ""), nil
+			}
+			eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(0)))
+			p := newTestPart(t, eng, tools.ModelTypeCategorical, WithReload(loader, "", 0))
+
+			err := p.Reload()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if eng.Closed() == tt.wantErr {
+				t.Errorf("previous engine closed = %v, want %v", eng.Closed(), !tt.wantErr)
+			}
+			if newEngine.Closed() != tt.wantErr {
+				t.Errorf("new engine closed = %v, want %v", newEngine.Closed(), tt.wantErr)
+			}
+			steering, _, err := p.model.Value(image.NewRGBA(image.Rect(0, 0, imgWidth, imgHeight)))
+			if err != nil {
+				t.Fatalf("Value() error = %v", err)
+			}
+			if steering != tt.wantSteering {
+				t.Errorf("Value() after reload = %v, want %v", steering, tt.wantSteering)
+			}
+		})
+	}
+}
+
+func TestPart_Reload_stackedWithWorkers(t *testing.T) {
+	input := engine.Tensor{Name: "input", Type: engine.TensorTypeUInt8, Shape: []int{1, imgHeight - horizon, imgWidth, 6}}
+	newEngine := fake.New([]engine.Tensor{input}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(14)))
+	loader := func() (*Model, error) {
+		return NewModel(newEngine, tools.ModelTypeCategorical, "new.tflite", DefaultPreprocessing(imgWidth, imgHeight, horizon), DefaultDecoding(), "", ""), nil
+	}
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(0)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithReload(loader, "", 0), WithWorkers(2))
+
+	if err := p.Reload(); err == nil {
+		t.Fatalf("Reload() of model that expects many frames with 2 workers, want error")
+	}
+	if !newEngine.Closed() || eng.Closed() {
+		t.Errorf("engines closed = %v/%v, want new engine closed and current one kept", newEngine.Closed(), eng.Closed())
+	}
+}
+
+func TestPart_runModelWatcher(t *testing.T) {
+	modelPath := filepath.Join(t.TempDir(), "model.tflite")
+	if err := os.WriteFile(modelPath, []byte("v1"), 0644); err != nil {
+		t.Fatalf("unable to write model file: %v", err)
+	}
+	newEngine := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(14)))
+	loader := func() (*Model, error) {
+		return NewModel(newEngine, tools.ModelTypeCategorical, modelPath, DefaultPreprocessing(imgWidth, imgHeight, horizon), DefaultDecoding(), "", ""), nil
+	}
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(0)))
+	m := NewModel(eng, tools.ModelTypeCategorical, modelPath, DefaultPreprocessing(imgWidth, imgHeight, horizon), DefaultDecoding(), "", "")
+	if err := m.Load(); err != nil {
+		t.Fatalf("unable to load fake engine: %v", err)
+	}
+	p := NewPart(nil, m, "steering", "camera", WithReload(loader, "", 10*time.Millisecond))
+
+	cancel := make(chan interface{})
+	done := make(chan interface{})
+	go func() {
+		defer close(done)
+		p.runModelWatcher(cancel)
+	}()
+	defer func() {
+		close(cancel)
+		<-done
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	if eng.Closed() {
+		t.Fatalf("model reloaded without file change")
+	}
+	if err := os.WriteFile(modelPath, []byte("v2, bigger"), 0644); err != nil {
+		t.Fatalf("unable to write model file: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !eng.Closed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("model not reloaded after file change")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(newEngine.Calls()) != 1 {
+		t.Errorf("new model warmed up with %d inferences, want 1", len(newEngine.Calls()))
+	}
+}
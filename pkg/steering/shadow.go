@@ -0,0 +1,120 @@
+package steering
+
+import (
+	"context"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"image"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WithShadow run model alongside primary model, on the same frames, and publish its steering on topic. Shadow
+// predictions don't affect published steering, their differences with primary predictions are recorded by
+// robocar.shadow_disagreement metric
+func WithShadow(model *Model, topic string) Option {
+	return func(p *Part) {
+		p.shadow = &shadow{model: model, topic: topic}
+	}
+}
+
+// shadow is a candidate model run in background
+type shadow struct {
+	model   *Model
+	topic   string
+	history *frameHistory
+
+	// busy is set while a frame is processed, frames received meanwhile are skipped so that shadow never delays
+	// primary model
+	busy int32
+	wg   sync.WaitGroup
+}
+
+// primaryResult is the result of primary model, compared to shadow prediction
+type primaryResult struct {
+	prediction Prediction
+	err        error
+}
+
+func (s *shadow) load(frameGap time.Duration) error {
+	if err := s.model.Load(); err != nil {
+		return err
+	}
+	if s.model.Frames() > 1 {
+		s.history = s.model.newFrameHistory(frameGap)
+	}
+	zap.S().Infof("shadow model %v published on topic '%v'", s.model.Path(), s.topic)
+	return nil
+}
+
+// close wait for running inference and release engine
+func (s *shadow) close() {
+	s.wg.Wait()
+	s.model.Close()
+}
+
+// start run shadow model on img of frame msg in background. Returned channel expects primary result to compare
+// with, it is nil when shadow is busy with a previous frame
+func (s *shadow) start(p *Part, img image.Image, msg *events.FrameMessage) chan<- primaryResult {
+	if !atomic.CompareAndSwapInt32(&s.busy, 0, 1) {
+		zap.L().Debug("shadow model busy, skip frame", zap.String("frame", msg.GetId().GetId()))
+		return nil
+	}
+	primary := make(chan primaryResult, 1)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer atomic.StoreInt32(&s.busy, 0)
+
+		prediction, err := s.model.predict(img, s.history, msg.GetId().GetCreatedAt().AsTime(), nil)
+		r := <-primary
+		if err != nil {
+			zap.S().Warnw("unable to compute shadow steering",
+				"frame", msg.GetId().GetId(),
+				"error", err,
+			)
+			return
+		}
+		s.publish(p, prediction, msg.GetId())
+		if r.err == nil {
+			s.compare(r.prediction, prediction)
+		}
+	}()
+	return primary
+}
+
+func (s *shadow) publish(p *Part, prediction Prediction, frameRef *events.FrameRef) {
+	if s.topic == "" {
+		return
+	}
+	payload, err := proto.Marshal(&events.SteeringMessage{
+		Steering:   prediction.Steering,
+		Confidence: prediction.SteeringConfidence,
+		FrameRef:   frameRef,
+	})
+	if err != nil {
+		zap.L().Error("unable to marshal protobuf message", zap.Error(err))
+		return
+	}
+	p.publish(s.topic, payload)
+}
+
+// compare record disagreement between primary and shadow predictions
+func (s *shadow) compare(primary, shadow Prediction) {
+	diff := math.Abs(float64(primary.Steering - shadow.Steering))
+	zap.L().Debug("shadow steering",
+		zap.Float32("primary", primary.Steering),
+		zap.Float32("shadow", shadow.Steering),
+		zap.Float64("disagreement", diff),
+	)
+	ctx := context.Background()
+	metrics.ShadowDisagreement.Record(ctx, diff, attribute.String("output", "steering"))
+	if primary.HasThrottle && shadow.HasThrottle {
+		metrics.ShadowDisagreement.Record(ctx, math.Abs(float64(primary.Throttle-shadow.Throttle)), attribute.String("output", "throttle"))
+	}
+}
@@ -0,0 +1,55 @@
+package steering
+
+import (
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func TestPart_processFrame_shadow(t *testing.T) {
+	msgs := recordPublish(t)
+	shadowEngine := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(14)))
+	shadowModel := NewModel(shadowEngine, tools.ModelTypeCategorical, "shadow.tflite", DefaultPreprocessing(imgWidth, imgHeight, horizon), DefaultDecoding(), "", "")
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(0)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithShadow(shadowModel, "shadow"))
+	if err := p.shadow.load(time.Second); err != nil {
+		t.Fatalf("unable to load shadow model: %v", err)
+	}
+
+	var msg events.FrameMessage
+	if err := proto.Unmarshal(framePayload(t, "1"), &msg); err != nil {
+		t.Fatalf("unable to unmarshal frame: %v", err)
+	}
+	p.processFrame(&msg)
+	p.shadow.wg.Wait()
+
+	want := map[string]float32{"steering": -1., "shadow": 1.}
+	if len(*msgs) != len(want) {
+		t.Fatalf("published %d messages, want %d", len(*msgs), len(want))
+	}
+	for _, m := range *msgs {
+		var steeringMsg events.SteeringMessage
+		if err := proto.Unmarshal(m.payload, &steeringMsg); err != nil {
+			t.Fatalf("unable to unmarshal steering message: %v", err)
+		}
+		if steeringMsg.Steering != want[m.topic] {
+			t.Errorf("steering on topic %v = %v, want %v", m.topic, steeringMsg.Steering, want[m.topic])
+		}
+	}
+
+	// Shadow busy with a previous frame is skipped
+	p.shadow.busy = 1
+	*msgs = nil
+	p.processFrame(&msg)
+	p.shadow.wg.Wait()
+	if len(*msgs) != 1 || (*msgs)[0].topic != "steering" {
+		t.Errorf("published %v messages while shadow is busy, want only primary steering", len(*msgs))
+	}
+	if calls := len(shadowEngine.Calls()); calls != 1 {
+		t.Errorf("shadow model invoked %d times, want 1", calls)
+	}
+}
@@ -6,128 +6,327 @@ import (
 	"fmt"
 	"github.com/cyrilix/robocar-base/service"
 	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/filter"
 	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/metrics"
-	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
-	"github.com/disintegration/imaging"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
-	"github.com/mattn/go-tflite"
-	"github.com/mattn/go-tflite/delegates/edgetpu"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 	"image"
 	_ "image/jpeg"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func NewPart(client mqtt.Client, modelType tools.ModelType, modelPath, steeringTopic, cameraTopic string, edgeVerbosity int, imgWidth, imgHeight, horizon int) *Part {
-	return &Part{
+type Option func(p *Part)
+
+// WithThrottleTopic publish throttle on topic when model has a throttle output
+func WithThrottleTopic(topic string) Option {
+	return func(p *Part) {
+		p.throttleTopic = topic
+	}
+}
+
+// WithDriveMode listen drive mode on topic. When pilotOnly is true, inference is paused until drive mode is PILOT
+func WithDriveMode(topic string, pilotOnly bool) Option {
+	return func(p *Part) {
+		p.driveModeTopic = topic
+		p.pilotOnly = pilotOnly
+	}
+}
+
+// WithMaxFrameAge drop frames older than maxAge when their processing start, 0 to disable
+func WithMaxFrameAge(maxAge time.Duration) Option {
+	return func(p *Part) {
+		p.maxFrameAge = maxAge
+	}
+}
+
+// WithFrameGap reset frame history of models that expect many frames when 2 frames are created more than maxGap
+// apart, 0 to never reset
+func WithFrameGap(maxGap time.Duration) Option {
+	return func(p *Part) {
+		p.frameGap = maxGap
+	}
+}
+
+// WithSteeringFilter smooth steering values before publishing
+func WithSteeringFilter(f filter.Filter) Option {
+	return func(p *Part) {
+		p.filter = f
+	}
+}
+
+// WithWorkers process up to workers frames concurrently, engine must support concurrent Run calls when workers is
+// greater than 1. Frames are processed by a single worker when a model expects many frames
+func WithWorkers(workers int) Option {
+	return func(p *Part) {
+		p.workers = workers
+	}
+}
+
+// WithFailSafe publish steering value with confidence when no inference succeeds within deadline or after maxErrors
+// consecutive errors, 0 disable each check. Degraded mode changes are published on statusTopic if not empty
+func WithFailSafe(deadline time.Duration, maxErrors int, steering, confidence float32, statusTopic string) Option {
+	return func(p *Part) {
+		if deadline <= 0 && maxErrors <= 0 {
+			return
+		}
+		p.watchdog = newWatchdog(deadline, maxErrors)
+		p.safeSteering = steering
+		p.safeConfidence = confidence
+		p.statusTopic = statusTopic
+	}
+}
+
+// WithQos set qos and retain flag used on all topics, retain flag is ignored for subscriptions
+func WithQos(qos byte, retain bool) Option {
+	return func(p *Part) {
+		p.defaultQos = mqttQos{qos: qos, retain: retain}
+	}
+}
+
+// WithTopicQos override qos and retain flag used for topic
+func WithTopicQos(topic string, qos byte, retain bool) Option {
+	return func(p *Part) {
+		p.topicsQos[topic] = mqttQos{qos: qos, retain: retain}
+	}
+}
+
+func NewPart(client mqtt.Client, model *Model, steeringTopic, cameraTopic string, opts ...Option) *Part {
+	p := &Part{
 		client:        client,
-		modelType:     modelType,
-		modelPath:     modelPath,
+		model:         model,
 		steeringTopic: steeringTopic,
 		cameraTopic:   cameraTopic,
-		edgeVebosity:  edgeVerbosity,
-		imgWidth:      imgWidth,
-		imgHeight:     imgHeight,
-		horizon:       horizon,
+		frames:        newFrameSlot(),
+		workers:       1,
+		frameGap:      defaultFrameGap,
+		topicsQos:     make(map[string]mqttQos),
+	}
+	for _, o := range opts {
+		o(p)
 	}
+	return p
+}
 
+// NewEnsemblePart instantiate a part that publish combined predictions of ensemble members
+func NewEnsemblePart(client mqtt.Client, ensemble *Ensemble, steeringTopic, cameraTopic string, opts ...Option) *Part {
+	p := NewPart(client, nil, steeringTopic, cameraTopic, opts...)
+	p.ensemble = ensemble
+	return p
 }
 
 type Part struct {
 	client        mqtt.Client
 	steeringTopic string
+	throttleTopic string
 	cameraTopic   string
 
-	cancel chan interface{}
+	defaultQos mqttQos
+	topicsQos  map[string]mqttQos
 
-	options      *tflite.InterpreterOptions
-	interpreter  *tflite.Interpreter
-	modelType    tools.ModelType
-	modelPath    string
-	model        *tflite.Model
-	edgeVebosity int
+	driveModeTopic string
+	pilotOnly      bool
+	// driveMode is the last events.DriveMode received
+	driveMode int32
+
+	cancel chan interface{}
 
-	imgWidth  int
-	imgHeight int
-	horizon   int
+	frames      *frameSlot
+	maxFrameAge time.Duration
+	workers     int
+	wgWorkers   sync.WaitGroup
+
+	// watchdog is nil when fail-safe is disabled
+	watchdog       *watchdog
+	safeSteering   float32
+	safeConfidence float32
+	statusTopic    string
+
+	// model is nil when ensemble is used. muModel guard model and history replacement by Reload
+	model    *Model
+	muModel  sync.RWMutex
+	ensemble *Ensemble
+	// filter smooth steering values, nil to publish raw values
+	filter   filter.Filter
+	muFilter sync.Mutex
+
+	// history is only used by models that expect many frames
+	history  *frameHistory
+	frameGap time.Duration
+
+	// shadow is nil when no shadow model is configured
+	shadow *shadow
+
+	// loader is nil when reload is disabled
+	loader        Loader
+	reloadTopic   string
+	watchInterval time.Duration
+	muReload      sync.Mutex
+
+	// registryWatcher is nil when registry isn't polled for new models
+	registryWatcher      RegistryWatcher
+	registryWatcherTopic string
 }
 
 func (p *Part) Start() error {
 	p.cancel = make(chan interface{})
-	p.model = tflite.NewModelFromFile(p.modelPath)
-	if p.model == nil {
-		return fmt.Errorf("cannot load model %v", p.modelPath)
-	}
-
-	// Get the list of devices
-	devices, err := edgetpu.DeviceList()
-	if err != nil {
-		return fmt.Errorf("could not get EdgeTPU devices: %w", err)
+	if p.ensemble != nil {
+		if err := p.ensemble.Load(p.frameGap); err != nil {
+			return err
+		}
+	} else {
+		if err := p.model.Load(); err != nil {
+			return err
+		}
+		p.initHistory()
 	}
-	if len(devices) == 0 {
-		return fmt.Errorf("no edge TPU devices found")
+	if p.shadow != nil {
+		if err := p.shadow.load(p.frameGap); err != nil {
+			zap.S().Errorw("unable to load shadow model, shadow disabled", "error", err)
+			p.shadow = nil
+		}
 	}
 
-	// Print the EdgeTPU version
-	edgetpuVersion, err := edgetpu.Version()
-	if err != nil {
-		return fmt.Errorf("cannot get EdgeTPU version: %w", err)
+	p.limitWorkers()
+	for i := 0; i < p.workers; i++ {
+		p.wgWorkers.Add(1)
+		go func() {
+			defer p.wgWorkers.Done()
+			p.runWorker(p.cancel)
+		}()
 	}
-	zap.S().Infof("EdgeTPU Version: %s", edgetpuVersion)
-	edgetpu.Verbosity(p.edgeVebosity)
-
-	p.options = tflite.NewInterpreterOptions()
-	p.options.SetNumThread(4)
-	p.options.SetErrorReporter(func(msg string, userData interface{}) {
-		zap.S().Errorw(msg,
-			"userData", userData,
-		)
-	}, nil)
-
-	zap.S().Infof("find %d edgetpu devices", len(devices))
-	zap.S().Infow("configure edgetpu",
-		"path", devices[0].Path,
-		"type", uint32(devices[0].Type),
-	)
-	// Add the first EdgeTPU device
-	d := edgetpu.New(devices[0])
-	if d == nil {
-		return fmt.Errorf("unable to create new EdgeTpu delegate")
+	if p.loader != nil && p.ensemble == nil && p.watchInterval > 0 {
+		p.wgWorkers.Add(1)
+		go func() {
+			defer p.wgWorkers.Done()
+			p.runModelWatcher(p.cancel)
+		}()
 	}
-	p.options.AddDelegate(d)
-
-	p.interpreter = tflite.NewInterpreter(p.model, p.options)
-	if p.interpreter == nil {
-		return fmt.Errorf("cannot create interpreter")
+	if p.registryWatcher != nil {
+		p.wgWorkers.Add(1)
+		go func() {
+			defer p.wgWorkers.Done()
+			p.runRegistryWatcher(p.cancel)
+		}()
+	}
+	if p.watchdog != nil && p.watchdog.deadline > 0 {
+		p.wgWorkers.Add(1)
+		go func() {
+			defer p.wgWorkers.Done()
+			p.runWatchdog(p.cancel)
+		}()
 	}
 
 	if err := registerCallbacks(p); err != nil {
 		zap.S().Errorw("unable to register callbacks", "error", err)
 		return err
 	}
+	if p.pilotOnly {
+		zap.S().Infof("inference paused until drive mode is %v", events.DriveMode_PILOT)
+	}
 
-	p.cancel = make(chan interface{})
 	<-p.cancel
 	return nil
 }
 
+// initHistory instantiate frame history if loaded model expects many frames
+func (p *Part) initHistory() {
+	if p.model.Frames() > 1 {
+		p.history = p.model.newFrameHistory(p.frameGap)
+	}
+}
+
+// limitWorkers process frames with a single worker when a model expects many frames: concurrent workers would add
+// frames to history out of order, and reset it
+func (p *Part) limitWorkers() {
+	if p.workers <= 1 || !p.stacksFrames() {
+		return
+	}
+	zap.S().Warnf("model expects many frames, process frames in order with 1 worker instead of %d", p.workers)
+	p.workers = 1
+}
+
+// stacksFrames return true if primary model, an ensemble member or shadow model expects many frames
+func (p *Part) stacksFrames() bool {
+	if p.shadow != nil && p.shadow.history != nil {
+		return true
+	}
+	if p.ensemble == nil {
+		return p.model.Frames() > 1
+	}
+	for _, h := range p.ensemble.histories {
+		if h != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Part) Stop() {
 	close(p.cancel)
-	service.StopService("steering", p.client, p.cameraTopic)
-	if p.interpreter != nil {
-		p.interpreter.Delete()
+	service.StopService("steering", p.client, p.topics()...)
+	p.wgWorkers.Wait()
+	// Wait for running reload
+	p.muReload.Lock()
+	defer p.muReload.Unlock()
+	if p.shadow != nil {
+		p.shadow.close()
+	}
+	if p.ensemble != nil {
+		p.ensemble.Close()
+		return
+	}
+	p.model.Close()
+}
+
+func (p *Part) topics() []string {
+	topics := []string{p.cameraTopic}
+	if p.driveModeTopic != "" {
+		topics = append(topics, p.driveModeTopic)
 	}
-	p.interpreter.Delete()
-	if p.options != nil {
-		p.options.Delete()
+	if p.loader != nil && p.reloadTopic != "" {
+		topics = append(topics, p.reloadTopic)
 	}
-	if p.model != nil {
-		p.model.Delete()
+	if p.registryWatcher != nil && p.registryWatcherTopic != "" {
+		topics = append(topics, p.registryWatcherTopic)
 	}
+	return topics
+}
+
+func (p *Part) onDriveMode(_ mqtt.Client, message mqtt.Message) {
+	var msg events.DriveModeMessage
+	err := proto.Unmarshal(message.Payload(), &msg)
+	if err != nil {
+		zap.S().Errorf("unable to unmarshal protobuf %T message: %v", &msg, err)
+		return
+	}
+
+	old := events.DriveMode(atomic.SwapInt32(&p.driveMode, int32(msg.GetDriveMode())))
+	if old == msg.GetDriveMode() {
+		return
+	}
+	metrics.SetDriveMode(int64(msg.GetDriveMode()))
+	// Values computed before a pause are outdated
+	p.resetFilter()
+	zap.S().Infow("drive mode changed",
+		"from", old,
+		"to", msg.GetDriveMode(),
+		"inference", p.inferenceEnabled(),
+	)
+}
+
+// inferenceEnabled return false when inference is paused by drive mode
+func (p *Part) inferenceEnabled() bool {
+	return !p.pilotOnly || events.DriveMode(atomic.LoadInt32(&p.driveMode)) == events.DriveMode_PILOT
 }
 
 func (p *Part) onFrame(_ mqtt.Client, message mqtt.Message) {
+	if !p.inferenceEnabled() {
+		return
+	}
+
 	var msg events.FrameMessage
 	err := proto.Unmarshal(message.Payload(), &msg)
 	if err != nil {
@@ -135,6 +334,12 @@ func (p *Part) onFrame(_ mqtt.Client, message mqtt.Message) {
 		return
 	}
 
+	if replaced := p.frames.put(&msg); replaced != nil {
+		dropFrame(replaced, dropReasonReplaced)
+	}
+}
+
+func (p *Part) processFrame(msg *events.FrameMessage) {
 	now := time.Now().UnixMilli()
 	frameAge := now - msg.Id.CreatedAt.AsTime().UnixMilli()
 	go metrics.FrameAge.Record(context.Background(), frameAge)
@@ -145,7 +350,14 @@ func (p *Part) onFrame(_ mqtt.Client, message mqtt.Message) {
 		return
 	}
 
-	steering, confidence, err := p.Value(img)
+	var shadow chan<- primaryResult
+	if p.shadow != nil {
+		shadow = p.shadow.start(p, img, msg)
+	}
+	prediction, err := p.predict(img, msg)
+	if shadow != nil {
+		shadow <- primaryResult{prediction: prediction, err: err}
+	}
 	inferenceDuration := time.Now().UnixMilli() - now
 	go metrics.InferenceDuration.Record(context.Background(), inferenceDuration)
 
@@ -154,15 +366,38 @@ func (p *Part) onFrame(_ mqtt.Client, message mqtt.Message) {
 			"frame", msg.GetId().GetId(),
 			"error", err,
 		)
+		if p.watchdog == nil {
+			return
+		}
+		if p.watchdog.failure() {
+			zap.S().Warnf("too many inference errors, enter degraded mode")
+			p.publishStatus(StatusDegraded)
+		}
+		if p.watchdog.isDegraded() {
+			p.publishSafeSteering(msg.Id)
+		}
 		return
 	}
+	if p.watchdog != nil && p.watchdog.success(time.Now()) {
+		zap.S().Infof("inference succeeded, leave degraded mode")
+		p.publishStatus(StatusOk)
+		p.resetFilter()
+	}
+	if p.filter != nil {
+		raw := prediction.Steering
+		prediction.Steering = p.applyFilter(raw, msg.GetId().GetCreatedAt().AsTime())
+		zap.L().Debug("filter steering",
+			zap.Float32("raw", raw),
+			zap.Float32("filtered", prediction.Steering),
+		)
+	}
 	zap.L().Debug("new steering value",
-		zap.Float32("steering", steering),
-		zap.Float32("confidence", confidence),
+		zap.Float32("steering", prediction.Steering),
+		zap.Float32("confidence", prediction.SteeringConfidence),
 	)
 	msgSteering := &events.SteeringMessage{
-		Steering:   steering,
-		Confidence: confidence,
+		Steering:   prediction.Steering,
+		Confidence: prediction.SteeringConfidence,
 		FrameRef:   msg.Id,
 	}
 
@@ -170,75 +405,170 @@ func (p *Part) onFrame(_ mqtt.Client, message mqtt.Message) {
 	if err != nil {
 		zap.L().Error("unable to marshal protobuf message", zap.Error(err))
 	}
-	publish(p.client, p.steeringTopic, payload)
+	p.publish(p.steeringTopic, payload)
+
+	if !prediction.HasThrottle || p.throttleTopic == "" {
+		return
+	}
+	zap.L().Debug("new throttle value",
+		zap.Float32("throttle", prediction.Throttle),
+		zap.Float32("confidence", prediction.ThrottleConfidence),
+	)
+	payload, err = proto.Marshal(&events.ThrottleMessage{
+		Throttle:   prediction.Throttle,
+		Confidence: prediction.ThrottleConfidence,
+		FrameRef:   msg.Id,
+	})
+	if err != nil {
+		zap.L().Error("unable to marshal protobuf message", zap.Error(err))
+	}
+	p.publish(p.throttleTopic, payload)
 }
 
-func (p *Part) Value(img image.Image) (float32, float32, error) {
-	status := p.interpreter.AllocateTensors()
-	if status != tflite.OK {
-		return 0., 0., fmt.Errorf("tensor allocate failed: %v", status)
+// predict run model, or ensemble members, on img of frame msg. Ensemble members predictions are published on
+// their debug topics
+func (p *Part) predict(img image.Image, msg *events.FrameMessage) (Prediction, error) {
+	at := msg.GetId().GetCreatedAt().AsTime()
+	if p.ensemble == nil {
+		p.muModel.RLock()
+		defer p.muModel.RUnlock()
+		return p.model.predict(img, p.history, at, nil)
 	}
 
-	input := p.interpreter.GetInputTensor(0)
+	prediction, results, err := p.ensemble.predict(img, at)
+	for i, r := range results {
+		topic := p.ensemble.members[i].Topic
+		if topic == "" || r.err != nil {
+			continue
+		}
+		payload, err := proto.Marshal(&events.SteeringMessage{
+			Steering:   r.prediction.Steering,
+			Confidence: r.prediction.SteeringConfidence,
+			FrameRef:   msg.Id,
+		})
+		if err != nil {
+			zap.L().Error("unable to marshal protobuf message", zap.Error(err))
+			continue
+		}
+		p.publish(topic, payload)
+	}
+	return prediction, err
+}
 
-	dx := img.Bounds().Dx()
-	dy := img.Bounds().Dy()
+func (p *Part) applyFilter(steering float32, at time.Time) float32 {
+	p.muFilter.Lock()
+	defer p.muFilter.Unlock()
+	return p.filter.Apply(steering, at)
+}
 
-	if dx != p.imgWidth || dy != p.imgHeight {
-		img = imaging.Resize(img, p.imgWidth, p.imgHeight, imaging.NearestNeighbor)
-	}
-	if p.horizon > 0 {
-		img = imaging.Crop(img, image.Rect(0, p.horizon, img.Bounds().Dx(), img.Bounds().Dy()))
+func (p *Part) resetFilter() {
+	if p.filter == nil {
+		return
 	}
+	p.muFilter.Lock()
+	defer p.muFilter.Unlock()
+	p.filter.Reset()
+}
 
-	dx = img.Bounds().Dx()
-	dy = img.Bounds().Dy()
-
-	bb := make([]uint8, dx*dy*3)
-	for y := 0; y < dy; y++ {
-		for x := 0; x < dx; x++ {
-			r, g, b, _ := img.At(x, y).RGBA()
-			bb[(y*dx+x)*3+0] = uint8(float64(r) / 257.0)
-			bb[(y*dx+x)*3+1] = uint8(float64(g) / 257.0)
-			bb[(y*dx+x)*3+2] = uint8(float64(b) / 257.0)
+// runWatchdog publish safe steering while inference stalls, until cancel is closed
+func (p *Part) runWatchdog(cancel <-chan interface{}) {
+	ticker := time.NewTicker(p.watchdog.deadline / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cancel:
+			return
+		case now := <-ticker.C:
+			if !p.inferenceEnabled() {
+				p.watchdog.reset(now)
+				continue
+			}
+			entered, stalled := p.watchdog.check(now)
+			if entered {
+				zap.S().Warnf("no successful inference since %v, enter degraded mode", p.watchdog.deadline)
+				p.publishStatus(StatusDegraded)
+			}
+			if stalled {
+				p.publishSafeSteering(nil)
+			}
 		}
 	}
-	status = input.CopyFromBuffer(bb)
-	if status != tflite.OK {
-		return 0., 0., fmt.Errorf("input copy from buffer failed: %v", status)
+}
+
+func (p *Part) publishSafeSteering(frameRef *events.FrameRef) {
+	payload, err := proto.Marshal(&events.SteeringMessage{
+		Steering:   p.safeSteering,
+		Confidence: p.safeConfidence,
+		FrameRef:   frameRef,
+	})
+	if err != nil {
+		zap.L().Error("unable to marshal protobuf message", zap.Error(err))
+		return
 	}
+	p.publish(p.steeringTopic, payload)
+}
 
-	status = p.interpreter.Invoke()
-	if status != tflite.OK {
-		return 0., 0., fmt.Errorf("invoke failed: %v", status)
+func (p *Part) publishStatus(status string) {
+	if p.statusTopic == "" {
+		return
 	}
+	p.publish(p.statusTopic, []byte(status))
+}
 
-	output := p.interpreter.GetOutputTensor(0).UInt8s()
-	zap.L().Debug("raw steering", zap.Uint8s("result", output))
+// mqttQos configure mqtt delivery on a topic
+type mqttQos struct {
+	qos    byte
+	retain bool
+}
 
-	var steering, score float64
-	switch p.modelType {
-	case tools.ModelTypeCategorical:
-		steering, score = tools.LinearBin(output, 15, -1, 2.0)
-	case tools.ModelTypeLinear:
-		steering = 2*(float64(output[0])/255.) - 1.
-		score = 0.6
+func (p *Part) qosFor(topic string) mqttQos {
+	if q, ok := p.topicsQos[topic]; ok {
+		return q
 	}
-	zap.L().Debug("found steering",
-		zap.Float64("steering", steering),
-		zap.Float64("score", score),
-	)
-	return float32(steering), float32(score), nil
+	return p.defaultQos
+}
+
+func (p *Part) publish(topic string, payload []byte) {
+	q := p.qosFor(topic)
+	publish(p.client, topic, q.qos, q.retain, payload)
 }
 
 var registerCallbacks = func(p *Part) error {
-	err := service.RegisterCallback(p.client, p.cameraTopic, p.onFrame)
+	err := subscribe(p.client, p.cameraTopic, p.qosFor(p.cameraTopic).qos, p.onFrame)
 	if err != nil {
 		return fmt.Errorf("unable to register callback: %w", err)
 	}
+	if p.driveModeTopic != "" {
+		err = subscribe(p.client, p.driveModeTopic, p.qosFor(p.driveModeTopic).qos, p.onDriveMode)
+		if err != nil {
+			return fmt.Errorf("unable to register drive mode callback: %w", err)
+		}
+	}
+	if p.loader != nil && p.reloadTopic != "" {
+		err = subscribe(p.client, p.reloadTopic, p.qosFor(p.reloadTopic).qos, p.onReload)
+		if err != nil {
+			return fmt.Errorf("unable to register reload callback: %w", err)
+		}
+	}
+	if p.registryWatcher != nil && p.registryWatcherTopic != "" {
+		err = subscribe(p.client, p.registryWatcherTopic, p.qosFor(p.registryWatcherTopic).qos, p.onRegistryWatcher)
+		if err != nil {
+			return fmt.Errorf("unable to register registry watcher callback: %w", err)
+		}
+	}
+	return nil
+}
+
+func subscribe(client mqtt.Client, topic string, qos byte, callback mqtt.MessageHandler) error {
+	zap.S().Infof("Register callback on topic %v with qos %v", topic, qos)
+	token := client.Subscribe(topic, qos, callback)
+	token.Wait()
+	if token.Error() != nil {
+		return fmt.Errorf("unable to register callback on topic %s: %w", topic, token.Error())
+	}
 	return nil
 }
 
-var publish = func(client mqtt.Client, topic string, payload []byte) {
-	client.Publish(topic, 0, false, payload)
+var publish = func(client mqtt.Client, topic string, qos byte, retain bool, payload []byte) {
+	client.Publish(topic, qos, retain, payload)
 }
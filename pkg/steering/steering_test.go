@@ -0,0 +1,474 @@
+package steering
+
+import (
+	"encoding/binary"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/filter"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"image"
+	"image/color"
+	"math"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	imgWidth  = 160
+	imgHeight = 120
+	horizon   = 20
+)
+
+var (
+	inputTensor = engine.Tensor{
+		Name:         "input",
+		Type:         engine.TensorTypeUInt8,
+		Shape:        []int{1, imgHeight - horizon, imgWidth, 3},
+		Quantization: engine.Quantization{Scale: 1. / 255., ZeroPoint: 0},
+	}
+	categoricalTensor = engine.Tensor{
+		Name:         "angle",
+		Type:         engine.TensorTypeUInt8,
+		Shape:        []int{1, 15},
+		Quantization: engine.Quantization{Scale: 1. / 255., ZeroPoint: 0},
+	}
+	linearTensor = engine.Tensor{
+		Name:         "angle",
+		Type:         engine.TensorTypeUInt8,
+		Shape:        []int{1, 1},
+		Quantization: engine.Quantization{Scale: 1. / 128., ZeroPoint: 128},
+	}
+	legacyLinearTensor = engine.Tensor{
+		Name:  "angle",
+		Type:  engine.TensorTypeUInt8,
+		Shape: []int{1, 1},
+	}
+	floatCategoricalTensor = engine.Tensor{
+		Name:  "angle",
+		Type:  engine.TensorTypeFloat32,
+		Shape: []int{1, 15},
+	}
+)
+
+func bins(idx int) []byte {
+	b := make([]byte, 15)
+	b[idx] = 255
+	return b
+}
+
+func floatBins(idx int, score float32) []byte {
+	b := make([]byte, 15*4)
+	binary.LittleEndian.PutUint32(b[idx*4:], math.Float32bits(score))
+	return b
+}
+
+type fakeMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (f *fakeMessage) Duplicate() bool   { return false }
+func (f *fakeMessage) Qos() byte         { return 0 }
+func (f *fakeMessage) Retained() bool    { return false }
+func (f *fakeMessage) Topic() string     { return f.topic }
+func (f *fakeMessage) MessageID() uint16 { return 0 }
+func (f *fakeMessage) Payload() []byte   { return f.payload }
+func (f *fakeMessage) Ack()              {}
+
+// processPending process frame waiting in slot, as a worker would do
+func processPending(p *Part) {
+	p.frames.mu.Lock()
+	frame := p.frames.frame
+	p.frames.frame = nil
+	p.frames.mu.Unlock()
+	if frame != nil {
+		p.processFrame(frame)
+	}
+}
+
+type published struct {
+	topic   string
+	qos     byte
+	retain  bool
+	payload []byte
+}
+
+func recordPublish(t *testing.T) *[]published {
+	var msgs []published
+	var mu sync.Mutex
+	oldPublish := publish
+	publish = func(_ mqtt.Client, topic string, qos byte, retain bool, payload []byte) {
+		// Shadow model publish from its own goroutine
+		mu.Lock()
+		defer mu.Unlock()
+		msgs = append(msgs, published{topic: topic, qos: qos, retain: retain, payload: payload})
+	}
+	t.Cleanup(func() { publish = oldPublish })
+	return &msgs
+}
+
+func loadPart(t *testing.T, modelType tools.ModelType, output engine.Tensor, script fake.Script) (*Part, *fake.Engine) {
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{output}, script)
+	return newTestPart(t, eng, modelType), eng
+}
+
+func newTestPart(t *testing.T, eng *fake.Engine, modelType tools.ModelType, opts ...Option) *Part {
+	m := NewModel(eng, modelType, "model.tflite", DefaultPreprocessing(imgWidth, imgHeight, horizon), DefaultDecoding(), "", "")
+	if err := m.Load(); err != nil {
+		t.Fatalf("unable to load fake engine: %v", err)
+	}
+	p := NewPart(nil, m, "steering", "camera", opts...)
+	p.initHistory()
+	return p
+}
+
+func TestPart_Value(t *testing.T) {
+	tests := []struct {
+		name           string
+		modelType      tools.ModelType
+		output         engine.Tensor
+		script         fake.Script
+		wantSteering   float32
+		wantConfidence float32
+		wantErr        bool
+	}{
+		{name: "categorical center", modelType: tools.ModelTypeCategorical, output: categoricalTensor, script: fake.Fixed(bins(7)), wantSteering: 0., wantConfidence: 1.},
+		{name: "categorical left", modelType: tools.ModelTypeCategorical, output: categoricalTensor, script: fake.Fixed(bins(0)), wantSteering: -1., wantConfidence: 1.},
+		{name: "categorical right", modelType: tools.ModelTypeCategorical, output: categoricalTensor, script: fake.Fixed(bins(14)), wantSteering: 1., wantConfidence: 1.},
+		{name: "categorical float32", modelType: tools.ModelTypeCategorical, output: floatCategoricalTensor, script: fake.Fixed(floatBins(3, 0.75)), wantSteering: -4. / 7., wantConfidence: 0.75},
+		{name: "linear right", modelType: tools.ModelTypeLinear, output: linearTensor, script: fake.Fixed([]byte{192}), wantSteering: 0.5, wantConfidence: 0.6},
+		{name: "linear left", modelType: tools.ModelTypeLinear, output: linearTensor, script: fake.Fixed([]byte{0}), wantSteering: -1., wantConfidence: 0.6},
+		{name: "linear without quantization", modelType: tools.ModelTypeLinear, output: legacyLinearTensor, script: fake.Fixed([]byte{255}), wantSteering: 1., wantConfidence: 0.6},
+		{name: "inference error", modelType: tools.ModelTypeLinear, output: linearTensor, script: fake.Sequence(), wantErr: true},
+	}
+	img := image.NewRGBA(image.Rect(0, 0, imgWidth, imgHeight))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, _ := loadPart(t, tt.modelType, tt.output, tt.script)
+			steering, confidence, err := p.model.Value(img)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Value() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if steering != tt.wantSteering {
+				t.Errorf("Value() steering = %v, want %v", steering, tt.wantSteering)
+			}
+			if confidence != tt.wantConfidence {
+				t.Errorf("Value() confidence = %v, want %v", confidence, tt.wantConfidence)
+			}
+		})
+	}
+}
+
+func TestPart_Value_preprocessing(t *testing.T) {
+	tests := []struct {
+		name         string
+		width        int
+		height       int
+		whiteRows    int
+		wantSteering float32
+	}{
+		{name: "horizon is cropped", width: imgWidth, height: imgHeight, whiteRows: horizon, wantSteering: -1.},
+		{name: "road is kept", width: imgWidth, height: imgHeight, whiteRows: horizon + 1, wantSteering: 1.},
+		{name: "image is resized", width: imgWidth * 2, height: imgHeight * 2, whiteRows: horizon * 2, wantSteering: -1.},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Turn right only if a white pixel reach model
+			p, eng := loadPart(t, tools.ModelTypeCategorical, categoricalTensor, fake.FromInput(func(inputs [][]byte) [][]byte {
+				for _, v := range inputs[0] {
+					if v != 0 {
+						return [][]byte{bins(14)}
+					}
+				}
+				return [][]byte{bins(0)}
+			}))
+
+			img := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					c := color.RGBA{A: 255}
+					if y < tt.whiteRows {
+						c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+					}
+					img.Set(x, y, c)
+				}
+			}
+
+			steering, _, err := p.model.Value(img)
+			if err != nil {
+				t.Errorf("Value() unexpected error: %v", err)
+				return
+			}
+			if steering != tt.wantSteering {
+				t.Errorf("Value() steering = %v, want %v", steering, tt.wantSteering)
+			}
+			if calls := eng.Calls(); len(calls) != 1 || len(calls[0][0]) != inputTensor.ByteSize() {
+				t.Errorf("unexpected model inputs: %v calls", len(calls))
+			}
+		})
+	}
+}
+
+func TestModel_PredictTimed(t *testing.T) {
+	p, _ := loadPart(t, tools.ModelTypeCategorical, categoricalTensor, fake.Fixed(bins(7)))
+
+	var stages []string
+	_, err := p.model.PredictTimed(image.NewRGBA(image.Rect(0, 0, imgWidth, imgHeight)), func(stage string, d time.Duration) {
+		if d < 0 {
+			t.Errorf("PredictTimed() negative duration %v for stage %v", d, stage)
+		}
+		stages = append(stages, stage)
+	})
+	if err != nil {
+		t.Fatalf("PredictTimed() unexpected error: %v", err)
+	}
+	want := []string{StageResize, StageCrop, StagePack, StageRun, StageDecode}
+	if !reflect.DeepEqual(stages, want) {
+		t.Errorf("PredictTimed() stages = %v, want %v", stages, want)
+	}
+}
+
+func TestPart_onFrame(t *testing.T) {
+	msgs := recordPublish(t)
+	p, _ := loadPart(t, tools.ModelTypeCategorical, categoricalTensor, fake.Sequence(
+		[][]byte{bins(0)},
+		[][]byte{bins(14)},
+	))
+
+	jpg, err := os.ReadFile("test_data/image.jpg")
+	if err != nil {
+		t.Fatalf("unable to read test image: %v", err)
+	}
+
+	for i, want := range []float32{-1., 1.} {
+		frameRef := &events.FrameRef{Name: "camera", Id: string(rune('a' + i)), CreatedAt: timestamppb.Now()}
+		payload, err := proto.Marshal(&events.FrameMessage{Id: frameRef, Frame: jpg})
+		if err != nil {
+			t.Fatalf("unable to marshal frame: %v", err)
+		}
+
+		p.onFrame(nil, &fakeMessage{topic: "camera", payload: payload})
+		processPending(p)
+
+		if len(*msgs) != i+1 {
+			t.Fatalf("onFrame() published %d messages, want %d", len(*msgs), i+1)
+		}
+		msg := (*msgs)[i]
+		if msg.topic != "steering" {
+			t.Errorf("onFrame() published on topic %v, want %v", msg.topic, "steering")
+		}
+		var steeringMsg events.SteeringMessage
+		if err := proto.Unmarshal(msg.payload, &steeringMsg); err != nil {
+			t.Fatalf("unable to unmarshal steering message: %v", err)
+		}
+		if steeringMsg.Steering != want {
+			t.Errorf("onFrame() steering = %v, want %v", steeringMsg.Steering, want)
+		}
+		if !proto.Equal(steeringMsg.FrameRef, frameRef) {
+			t.Errorf("onFrame() frameRef = %v, want %v", steeringMsg.FrameRef, frameRef)
+		}
+	}
+}
+
+func TestPart_onFrame_filter(t *testing.T) {
+	msgs := recordPublish(t)
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Sequence(
+		[][]byte{bins(0)},
+		[][]byte{bins(14)},
+		[][]byte{bins(14)},
+	))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithSteeringFilter(filter.NewEMA(0.5)))
+
+	steerings := func() []float32 {
+		var result []float32
+		for _, m := range *msgs {
+			var steeringMsg events.SteeringMessage
+			if err := proto.Unmarshal(m.payload, &steeringMsg); err != nil {
+				t.Fatalf("unable to unmarshal steering message: %v", err)
+			}
+			result = append(result, steeringMsg.Steering)
+		}
+		return result
+	}
+
+	p.onFrame(nil, &fakeMessage{topic: "camera", payload: framePayload(t, "1")})
+	processPending(p)
+	p.onFrame(nil, &fakeMessage{topic: "camera", payload: framePayload(t, "2")})
+	processPending(p)
+	if got, want := steerings(), []float32{-1., 0.}; !reflect.DeepEqual(got, want) {
+		t.Errorf("onFrame() steering = %v, want %v", got, want)
+	}
+
+	// Filter restart on drive mode change
+	payload, err := proto.Marshal(&events.DriveModeMessage{DriveMode: events.DriveMode_PILOT})
+	if err != nil {
+		t.Fatalf("unable to marshal drive mode: %v", err)
+	}
+	p.onDriveMode(nil, &fakeMessage{topic: "drive_mode", payload: payload})
+	p.onFrame(nil, &fakeMessage{topic: "camera", payload: framePayload(t, "3")})
+	processPending(p)
+	if got, want := steerings(), []float32{-1., 0., 1.}; !reflect.DeepEqual(got, want) {
+		t.Errorf("onFrame() steering after drive mode change = %v, want %v", got, want)
+	}
+}
+
+func TestPart_onFrame_badFrame(t *testing.T) {
+	msgs := recordPublish(t)
+	p, eng := loadPart(t, tools.ModelTypeCategorical, categoricalTensor, fake.Fixed(bins(7)))
+
+	payload, err := proto.Marshal(&events.FrameMessage{
+		Id:    &events.FrameRef{Name: "camera", Id: "1", CreatedAt: timestamppb.Now()},
+		Frame: []byte("not a jpeg"),
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal frame: %v", err)
+	}
+	p.onFrame(nil, &fakeMessage{topic: "camera", payload: payload})
+	processPending(p)
+
+	if len(*msgs) != 0 {
+		t.Errorf("onFrame() published %d messages on bad frame", len(*msgs))
+	}
+	if len(eng.Calls()) != 0 {
+		t.Errorf("onFrame() invoked model on bad frame")
+	}
+}
+
+func TestPart_onFrame_throttle(t *testing.T) {
+	msgs := recordPublish(t)
+	throttleTensor := engine.Tensor{Name: "throttle", Type: engine.TensorTypeUInt8, Shape: []int{1, 20}, Quantization: engine.Quantization{Scale: 1. / 255.}}
+	throttleBins := make([]byte, 20)
+	throttleBins[10] = 200
+
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{throttleTensor, categoricalTensor}, fake.Fixed(throttleBins, bins(14)))
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithThrottleTopic("throttle"))
+
+	jpg, err := os.ReadFile("test_data/image.jpg")
+	if err != nil {
+		t.Fatalf("unable to read test image: %v", err)
+	}
+	frameRef := &events.FrameRef{Name: "camera", Id: "1", CreatedAt: timestamppb.Now()}
+	payload, err := proto.Marshal(&events.FrameMessage{Id: frameRef, Frame: jpg})
+	if err != nil {
+		t.Fatalf("unable to marshal frame: %v", err)
+	}
+
+	p.onFrame(nil, &fakeMessage{topic: "camera", payload: payload})
+	processPending(p)
+
+	if len(*msgs) != 2 {
+		t.Fatalf("onFrame() published %d messages, want 2", len(*msgs))
+	}
+	var steeringMsg events.SteeringMessage
+	if (*msgs)[0].topic != "steering" {
+		t.Errorf("onFrame() published steering on topic %v", (*msgs)[0].topic)
+	}
+	if err := proto.Unmarshal((*msgs)[0].payload, &steeringMsg); err != nil {
+		t.Fatalf("unable to unmarshal steering message: %v", err)
+	}
+	if steeringMsg.Steering != 1. {
+		t.Errorf("onFrame() steering = %v, want %v", steeringMsg.Steering, 1.)
+	}
+
+	var throttleMsg events.ThrottleMessage
+	if (*msgs)[1].topic != "throttle" {
+		t.Errorf("onFrame() published throttle on topic %v", (*msgs)[1].topic)
+	}
+	if err := proto.Unmarshal((*msgs)[1].payload, &throttleMsg); err != nil {
+		t.Fatalf("unable to unmarshal throttle message: %v", err)
+	}
+	if throttleMsg.Throttle != 0.25 {
+		t.Errorf("onFrame() throttle = %v, want %v", throttleMsg.Throttle, 0.25)
+	}
+	if throttleMsg.Confidence != float32(200./255.) {
+		t.Errorf("onFrame() throttle confidence = %v, want %v", throttleMsg.Confidence, float32(200./255.))
+	}
+	if !proto.Equal(throttleMsg.FrameRef, steeringMsg.FrameRef) || !proto.Equal(throttleMsg.FrameRef, frameRef) {
+		t.Errorf("onFrame() throttle frameRef = %v, want %v", throttleMsg.FrameRef, frameRef)
+	}
+}
+
+func framePayload(t *testing.T, id string) []byte {
+	jpg, err := os.ReadFile("test_data/image.jpg")
+	if err != nil {
+		t.Fatalf("unable to read test image: %v", err)
+	}
+	payload, err := proto.Marshal(&events.FrameMessage{
+		Id:    &events.FrameRef{Name: "camera", Id: id, CreatedAt: timestamppb.Now()},
+		Frame: jpg,
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal frame: %v", err)
+	}
+	return payload
+}
+
+func TestPart_onDriveMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		pilotOnly     bool
+		driveModes    []events.DriveMode
+		wantPublished int
+	}{
+		{name: "always infer", pilotOnly: false, driveModes: nil, wantPublished: 1},
+		{name: "always infer in user mode", pilotOnly: false, driveModes: []events.DriveMode{events.DriveMode_USER}, wantPublished: 1},
+		{name: "paused before drive mode", pilotOnly: true, driveModes: nil, wantPublished: 0},
+		{name: "paused in user mode", pilotOnly: true, driveModes: []events.DriveMode{events.DriveMode_USER}, wantPublished: 0},
+		{name: "pilot mode", pilotOnly: true, driveModes: []events.DriveMode{events.DriveMode_PILOT}, wantPublished: 1},
+		{name: "back to user mode", pilotOnly: true, driveModes: []events.DriveMode{events.DriveMode_PILOT, events.DriveMode_USER}, wantPublished: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgs := recordPublish(t)
+			eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, fake.Fixed(bins(7)))
+			p := newTestPart(t, eng, tools.ModelTypeCategorical, WithDriveMode("drive_mode", tt.pilotOnly))
+
+			for _, m := range tt.driveModes {
+				payload, err := proto.Marshal(&events.DriveModeMessage{DriveMode: m})
+				if err != nil {
+					t.Fatalf("unable to marshal drive mode: %v", err)
+				}
+				p.onDriveMode(nil, &fakeMessage{topic: "drive_mode", payload: payload})
+			}
+			p.onFrame(nil, &fakeMessage{topic: "camera", payload: framePayload(t, "1")})
+			processPending(p)
+
+			if len(*msgs) != tt.wantPublished {
+				t.Errorf("onFrame() published %d messages, want %d", len(*msgs), tt.wantPublished)
+			}
+			if len(eng.Calls()) != tt.wantPublished {
+				t.Errorf("onFrame() invoked model %d times, want %d", len(eng.Calls()), tt.wantPublished)
+			}
+		})
+	}
+}
+
+func TestPart_publish_qos(t *testing.T) {
+	msgs := recordPublish(t)
+	p := NewPart(nil, nil, "steering", "camera",
+		WithQos(1, false),
+		WithTopicQos("status", 2, true),
+	)
+
+	p.publish("steering", nil)
+	p.publish("status", nil)
+
+	want := []published{{topic: "steering", qos: 1, retain: false}, {topic: "status", qos: 2, retain: true}}
+	if len(*msgs) != len(want) {
+		t.Fatalf("publish() published %d messages, want %d", len(*msgs), len(want))
+	}
+	for i, w := range want {
+		got := (*msgs)[i]
+		if got.topic != w.topic || got.qos != w.qos || got.retain != w.retain {
+			t.Errorf("publish() = %v/%v/%v, want %v/%v/%v", got.topic, got.qos, got.retain, w.topic, w.qos, w.retain)
+		}
+	}
+}
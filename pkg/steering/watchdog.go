@@ -0,0 +1,82 @@
+package steering
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	StatusOk       = "ok"
+	StatusDegraded = "degraded"
+)
+
+func newWatchdog(deadline time.Duration, maxErrors int) *watchdog {
+	return &watchdog{
+		deadline:    deadline,
+		maxErrors:   maxErrors,
+		lastSuccess: time.Now(),
+	}
+}
+
+// watchdog detect when inference stalls (no success since deadline) or fails (maxErrors consecutive errors)
+type watchdog struct {
+	mu        sync.Mutex
+	deadline  time.Duration
+	maxErrors int
+
+	lastSuccess time.Time
+	errors      int
+	degraded    bool
+}
+
+// success record a successful inference, return true if degraded mode is left
+func (w *watchdog) success(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastSuccess = now
+	w.errors = 0
+	return w.setDegraded(false)
+}
+
+// failure record an inference error, return true if degraded mode is entered
+func (w *watchdog) failure() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errors += 1
+	if w.maxErrors <= 0 || w.errors < w.maxErrors {
+		return false
+	}
+	return w.setDegraded(true)
+}
+
+// check return true if no successful inference happened since deadline. First returned value is true if degraded
+// mode is entered
+func (w *watchdog) check(now time.Time) (entered bool, stalled bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.deadline <= 0 || now.Sub(w.lastSuccess) <= w.deadline {
+		return false, false
+	}
+	return w.setDegraded(true), true
+}
+
+// reset restart deadline without leaving degraded mode, used when inference restart after a pause
+func (w *watchdog) reset(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastSuccess = now
+	w.errors = 0
+}
+
+func (w *watchdog) isDegraded() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.degraded
+}
+
+// setDegraded return true if state changed
+func (w *watchdog) setDegraded(degraded bool) bool {
+	changed := w.degraded != degraded
+	w.degraded = degraded
+	return changed
+}
@@ -0,0 +1,121 @@
+package steering
+
+import (
+	"errors"
+	"github.com/cyrilix/robocar-protobuf/go/events"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/engine/fake"
+	"github.com/cyrilix/robocar-steering-tflite-edgetpu/pkg/tools"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func Test_watchdog_failure(t *testing.T) {
+	w := newWatchdog(0, 3)
+	for i, want := range []bool{false, false, true, false} {
+		if got := w.failure(); got != want {
+			t.Errorf("failure() #%d = %v, want %v", i, got, want)
+		}
+	}
+	if !w.isDegraded() {
+		t.Errorf("isDegraded() = false after max errors")
+	}
+	if !w.success(time.Now()) {
+		t.Errorf("success() didn't leave degraded mode")
+	}
+	if w.failure() {
+		t.Errorf("failure() entered degraded mode, errors should be reset by success")
+	}
+}
+
+func Test_watchdog_check(t *testing.T) {
+	start := time.Now()
+	w := newWatchdog(time.Second, 0)
+	w.success(start)
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		wantEntered bool
+		wantStalled bool
+	}{
+		{name: "before deadline", now: start.Add(500 * time.Millisecond), wantEntered: false, wantStalled: false},
+		{name: "after deadline", now: start.Add(1500 * time.Millisecond), wantEntered: true, wantStalled: true},
+		{name: "still stalled", now: start.Add(2 * time.Second), wantEntered: false, wantStalled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entered, stalled := w.check(tt.now)
+			if entered != tt.wantEntered {
+				t.Errorf("check() entered = %v, want %v", entered, tt.wantEntered)
+			}
+			if stalled != tt.wantStalled {
+				t.Errorf("check() stalled = %v, want %v", stalled, tt.wantStalled)
+			}
+		})
+	}
+
+	w.reset(start.Add(2 * time.Second))
+	if _, stalled := w.check(start.Add(2500 * time.Millisecond)); stalled {
+		t.Errorf("check() stalled after reset")
+	}
+	if !w.isDegraded() {
+		t.Errorf("reset() left degraded mode without successful inference")
+	}
+}
+
+func TestPart_processFrame_failSafe(t *testing.T) {
+	msgs := recordPublish(t)
+	script := func(n int, _ [][]byte) ([][]byte, error) {
+		if n < 2 {
+			return nil, errors.New("inference failure")
+		}
+		return [][]byte{bins(14)}, nil
+	}
+	eng := fake.New([]engine.Tensor{inputTensor}, []engine.Tensor{categoricalTensor}, script)
+	p := newTestPart(t, eng, tools.ModelTypeCategorical, WithFailSafe(0, 2, 0.1, 0., "status"))
+
+	type want struct {
+		topic    string
+		status   string
+		steering float32
+	}
+	expected := [][]want{
+		{},
+		{{topic: "status", status: StatusDegraded}, {topic: "steering", steering: 0.1}},
+		{{topic: "status", status: StatusOk}, {topic: "steering", steering: 1.}},
+	}
+	for i, wants := range expected {
+		*msgs = nil
+		p.onFrame(nil, &fakeMessage{topic: "camera", payload: framePayload(t, "frame")})
+		processPending(p)
+
+		if len(*msgs) != len(wants) {
+			t.Fatalf("frame %d: published %d messages, want %d", i, len(*msgs), len(wants))
+		}
+		for j, w := range wants {
+			msg := (*msgs)[j]
+			if msg.topic != w.topic {
+				t.Errorf("frame %d: message %d published on %v, want %v", i, j, msg.topic, w.topic)
+				continue
+			}
+			if w.topic == "status" {
+				if string(msg.payload) != w.status {
+					t.Errorf("frame %d: status = %v, want %v", i, string(msg.payload), w.status)
+				}
+				continue
+			}
+			var steeringMsg events.SteeringMessage
+			if err := proto.Unmarshal(msg.payload, &steeringMsg); err != nil {
+				t.Fatalf("unable to unmarshal steering message: %v", err)
+			}
+			if steeringMsg.Steering != w.steering {
+				t.Errorf("frame %d: steering = %v, want %v", i, steeringMsg.Steering, w.steering)
+			}
+			if steeringMsg.GetFrameRef().GetId() != "frame" {
+				t.Errorf("frame %d: steering frameRef = %v", i, steeringMsg.GetFrameRef())
+			}
+		}
+	}
+}
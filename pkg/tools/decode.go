@@ -0,0 +1,105 @@
+package tools
+
+import "math"
+
+// Unbin return value of bin b, as inverse of donkeycar linear_bin, b may be fractional
+func Unbin(b float64, n int, offset float64, r float64) float64 {
+	return b*(r/(float64(n)+offset)) + offset
+}
+
+// ArgMax return index of the highest score, first one on ties
+func ArgMax(scores []float32) int {
+	best := 0
+	for i, s := range scores {
+		if s > scores[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// Probabilities write into dst the probability of each bin. Scores already forming a distribution, as softmax
+// outputs, are only normalized so that they sum to 1, others are considered as logits and go through a softmax
+func Probabilities(scores []float32, dst []float64) {
+	var sum float64
+	distribution := true
+	for i, s := range scores {
+		if s < 0 {
+			distribution = false
+		}
+		dst[i] = float64(s)
+		sum += dst[i]
+	}
+	// Quantized softmax outputs don't exactly sum to 1
+	if distribution && sum > 0.9 && sum < 1.1 {
+		for i := range dst {
+			dst[i] /= sum
+		}
+		return
+	}
+
+	max := dst[0]
+	for _, v := range dst {
+		max = math.Max(max, v)
+	}
+	sum = 0
+	for i, v := range dst {
+		dst[i] = math.Exp(v - max)
+		sum += dst[i]
+	}
+	for i := range dst {
+		dst[i] /= sum
+	}
+}
+
+// Expectation return mean bin index weighted by probabilities p
+func Expectation(p []float64) float64 {
+	var result float64
+	for i, v := range p {
+		result += float64(i) * v
+	}
+	return result
+}
+
+// TopK return mean bin index of the k most probable bins, weighted by their probabilities, and the sum of their
+// probabilities
+func TopK(p []float64, k int) (float64, float64) {
+	if k > len(p) {
+		k = len(p)
+	}
+	var mean, weight float64
+	// Bins are picked by decreasing probability, then increasing index, without allocation
+	prev := -1
+	for j := 0; j < k; j++ {
+		best := -1
+		for i, v := range p {
+			if prev >= 0 && (v > p[prev] || (v == p[prev] && i <= prev)) {
+				continue
+			}
+			if best < 0 || v > p[best] {
+				best = i
+			}
+		}
+		mean += float64(best) * p[best]
+		weight += p[best]
+		prev = best
+	}
+	if weight == 0 {
+		return Expectation(p), 0
+	}
+	return mean / weight, weight
+}
+
+// EntropyConfidence return 1 - H(p)/log(n): 1 when a single bin is probable, 0 when all bins are equiprobable
+func EntropyConfidence(p []float64) float64 {
+	if len(p) < 2 {
+		return 1.
+	}
+	var h float64
+	for _, v := range p {
+		if v > 0 {
+			h -= v * math.Log(v)
+		}
+	}
+	return 1. - h/math.Log(float64(len(p)))
+}
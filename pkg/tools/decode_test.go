@@ -0,0 +1,103 @@
+package tools
+
+import (
+	"math"
+	"testing"
+)
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-6
+}
+
+func Test_Probabilities(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []float32
+		want   []float64
+	}{
+		{name: "distribution", scores: []float32{0.25, 0.25, 0.5}, want: []float64{0.25, 0.25, 0.5}},
+		{name: "quantized distribution", scores: scores(64, 64, 127), want: []float64{64. / 255, 64. / 255, 127. / 255}},
+		{name: "logits", scores: []float32{0, 0, float32(math.Log(2))}, want: []float64{0.25, 0.25, 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]float64, len(tt.scores))
+			Probabilities(tt.scores, got)
+			var sum float64
+			for _, v := range got {
+				sum += v
+			}
+			if !almostEqual(sum, 1.) {
+				t.Errorf("Probabilities() sum = %v, want 1", sum)
+			}
+			for i := range tt.want {
+				if math.Abs(got[i]-tt.want[i]) > 0.01 {
+					t.Errorf("Probabilities() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func Test_TopK(t *testing.T) {
+	p := []float64{0.1, 0.5, 0., 0.3, 0.1}
+	tests := []struct {
+		name       string
+		k          int
+		want       float64
+		wantWeight float64
+	}{
+		{name: "top 1", k: 1, want: 1., wantWeight: 0.5},
+		{name: "top 2", k: 2, want: (0.5*1 + 0.3*3) / 0.8, wantWeight: 0.8},
+		{name: "ties pick first bins", k: 3, want: (0.1*0 + 0.5*1 + 0.3*3) / 0.9, wantWeight: 0.9},
+		{name: "all", k: 10, want: Expectation(p), wantWeight: 1.},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, weight := TopK(p, tt.k)
+			if !almostEqual(got, tt.want) || !almostEqual(weight, tt.wantWeight) {
+				t.Errorf("TopK() = %v, %v, want %v, %v", got, weight, tt.want, tt.wantWeight)
+			}
+		})
+	}
+}
+
+func Test_EntropyConfidence(t *testing.T) {
+	tests := []struct {
+		name string
+		p    []float64
+		want float64
+	}{
+		{name: "certain", p: []float64{0, 1, 0, 0}, want: 1.},
+		{name: "uniform", p: []float64{0.25, 0.25, 0.25, 0.25}, want: 0.},
+		{name: "two bins", p: []float64{0.5, 0.5, 0, 0}, want: 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EntropyConfidence(tt.p); !almostEqual(got, tt.want) {
+				t.Errorf("EntropyConfidence() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_Unbin(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []float32
+		want   float64
+	}{
+		{name: "center", scores: scores(0, 0, 0, 0, 0, 0, 0, 255, 0, 0, 0, 0, 0, 0, 0), want: 0.},
+		{name: "left", scores: scores(255, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0), want: -1.},
+		{name: "right", scores: scores(0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 255), want: 1.},
+		{name: "best score", scores: scores(0, 0, 0, 0, 0, 0, 0, 5, 10, 15, 20, 40, 100, 60, 5), want: 0.7142857142857142},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unbin(float64(ArgMax(tt.scores)), 15, -1, 2.0); !almostEqual(got, tt.want) {
+				t.Errorf("Unbin() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
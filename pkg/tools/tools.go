@@ -1,8 +1,7 @@
 package tools
 
 import (
-	"go.uber.org/zap"
-	"sort"
+	"math"
 	"strings"
 )
 
@@ -36,27 +35,14 @@ const (
 	ModelTypeLinear
 )
 
-// LinearBin  perform inverse linear_bin, taking
-func LinearBin(arr []uint8, n int, offset int, r float64) (float64, float64) {
-	outputSize := len(arr)
-	type result struct {
-		score float64
-		index int
+// Bin return index of the bin matching value a, inverse of Unbin
+func Bin(a float64, n int, offset float64, r float64) int {
+	b := int(math.Round((a - offset) / (r / (float64(n) + offset))))
+	if b < 0 {
+		return 0
 	}
-
-	var results []result
-	for i := 0; i < outputSize; i++ {
-		score := float64(int(arr[i])) / 255.0
-		results = append(results, result{score: score, index: i})
+	if b >= n {
+		return n - 1
 	}
-
-	zap.S().Debugf("raw result: %v", results)
-
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].score > results[j].score
-	})
-
-	b := results[0].index
-	a := float64(b)*(r/(float64(n)+float64(offset))) + float64(offset)
-	return a, results[0].score
+	return b
 }